@@ -0,0 +1,212 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+	"gorm.io/gorm"
+)
+
+// Codec 缓存值的序列化方式
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec 默认的 json 序列化
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// CacheOptions 缓存选项，nil 时使用默认值
+type CacheOptions struct {
+	Prefix      string        // redis key 前缀
+	TTL         time.Duration // 缓存时间，默认 10 分钟
+	Jitter      time.Duration // 缓存时间随机增加 [0, Jitter)，避免同时过期，默认 TTL 的 10%
+	NegativeTTL time.Duration // 记录不存在时的缓存时间，默认 1 分钟，小于 0 不缓存
+	LoadTimeout time.Duration // 未命中时加载的超时时间，默认 5 秒
+	Codec       Codec         // 默认 JSONCodec
+}
+
+const (
+	defaultCacheTTL         = 10 * time.Minute
+	defaultCacheNegativeTTL = time.Minute
+	defaultCacheLoadTimeout = 5 * time.Second
+)
+
+// 记录不存在时写入的占位值，不会是合法的 json
+var negativeValue = []byte("\x00nil")
+
+// Loader 缓存未命中时从数据库加载，记录不存在时返回 gorm.ErrRecordNotFound
+type Loader[T any] func(ctx context.Context, db *gorm.DB, key string) (T, error)
+
+// CacheAside 旁路缓存：先读 redis，未命中时从数据库加载并回写。
+// 数据源每次调用时从 DBManager 获取，热更新后自动使用新连接
+type CacheAside[T any] struct {
+	dbManager *DBManager
+	dbName    string
+	redisName string
+	opts      CacheOptions
+	group     singleflight.Group
+}
+
+// NewCacheAside 使用 DBManager 中名为 dbName 的数据库和 redisName 的 redis 创建缓存
+func NewCacheAside[T any](dbManager *DBManager, dbName string, redisName string, opts *CacheOptions) *CacheAside[T] {
+	var o CacheOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.TTL <= 0 {
+		o.TTL = defaultCacheTTL
+	}
+	if o.Jitter == 0 {
+		o.Jitter = o.TTL / 10
+	}
+	if o.NegativeTTL == 0 {
+		o.NegativeTTL = defaultCacheNegativeTTL
+	}
+	if o.LoadTimeout <= 0 {
+		o.LoadTimeout = defaultCacheLoadTimeout
+	}
+	if o.Codec == nil {
+		o.Codec = JSONCodec{}
+	}
+
+	return &CacheAside[T]{
+		dbManager: dbManager,
+		dbName:    dbName,
+		redisName: redisName,
+		opts:      o,
+	}
+}
+
+// Get 读取缓存，未命中时调用 load 加载，同一个 key 并发未命中时只加载一次。
+// 记录不存在时返回 gorm.ErrRecordNotFound。
+//
+// ctx 中带有 WithTx 的事务时直接在事务中加载，不读写缓存，避免读到旧值或把未提交的数据写入缓存
+func (c *CacheAside[T]) Get(ctx context.Context, key string, load Loader[T]) (T, error) {
+	var zero T
+	if tx, ok := TxFromContext(ctx, c.dbName); ok {
+		return load(ctx, tx, key)
+	}
+
+	client := c.dbManager.GetUniversalRedis(c.redisName)
+	if client == nil {
+		return zero, fmt.Errorf("redis %s not found", c.redisName)
+	}
+
+	data, err := client.Get(ctx, c.opts.Prefix+key).Bytes()
+	switch {
+	case err == nil:
+		if string(data) == string(negativeValue) {
+			return zero, gorm.ErrRecordNotFound
+		}
+		var value T
+		if err = c.opts.Codec.Unmarshal(data, &value); err == nil {
+			return value, nil
+		}
+		// 结构变更后旧缓存无法解析，重新加载覆盖
+		zap.L().Warn("cache value decode failed", zap.String("key", c.opts.Prefix+key), zap.Error(err))
+	case !errors.Is(err, redis.Nil):
+		// redis 不可用时直接读数据库
+		zap.L().Warn("cache get failed", zap.String("key", c.opts.Prefix+key), zap.Error(err))
+	}
+
+	// 加载结果由所有等待的调用方共享，不能因为第一个调用方取消而失败
+	ch := c.group.DoChan(key, func() (any, error) {
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.opts.LoadTimeout)
+		defer cancel()
+		return c.load(loadCtx, client, key, load)
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return zero, res.Err
+		}
+		return res.Val.(T), nil
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
+
+func (c *CacheAside[T]) load(ctx context.Context, client redis.UniversalClient, key string, load Loader[T]) (T, error) {
+	var zero T
+	db := c.dbManager.GetGormContext(ctx, c.dbName)
+	if db == nil {
+		return zero, fmt.Errorf("source %s not found", c.dbName)
+	}
+
+	value, err := load(ctx, db, key)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if c.opts.NegativeTTL > 0 {
+			if err := client.Set(ctx, c.opts.Prefix+key, negativeValue, c.opts.NegativeTTL).Err(); err != nil {
+				zap.L().Warn("cache set failed", zap.String("key", c.opts.Prefix+key), zap.Error(err))
+			}
+		}
+		return zero, gorm.ErrRecordNotFound
+	}
+	if err != nil {
+		return zero, err
+	}
+
+	if err := c.set(ctx, client, key, value); err != nil {
+		zap.L().Warn("cache set failed", zap.String("key", c.opts.Prefix+key), zap.Error(err))
+	}
+	return value, nil
+}
+
+// Set 直接写入缓存，用于更新数据库后主动刷新
+func (c *CacheAside[T]) Set(ctx context.Context, key string, value T) error {
+	client := c.dbManager.GetUniversalRedis(c.redisName)
+	if client == nil {
+		return fmt.Errorf("redis %s not found", c.redisName)
+	}
+	return c.set(ctx, client, key, value)
+}
+
+func (c *CacheAside[T]) set(ctx context.Context, client redis.UniversalClient, key string, value T) error {
+	data, err := c.opts.Codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return client.Set(ctx, c.opts.Prefix+key, data, c.ttl()).Err()
+}
+
+// Invalidate 删除缓存，更新或删除数据库记录后调用
+func (c *CacheAside[T]) Invalidate(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	client := c.dbManager.GetUniversalRedis(c.redisName)
+	if client == nil {
+		return fmt.Errorf("redis %s not found", c.redisName)
+	}
+
+	// 集群模式下多个 key 可能不在同一个 slot，逐个删除
+	pipe := client.Pipeline()
+	for _, key := range keys {
+		pipe.Del(ctx, c.opts.Prefix+key)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (c *CacheAside[T]) ttl() time.Duration {
+	if c.opts.Jitter <= 0 {
+		return c.opts.TTL
+	}
+	return c.opts.TTL + rand.N(c.opts.Jitter)
+}
@@ -0,0 +1,150 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type cachedPlayer struct {
+	ID   int
+	Name string
+}
+
+// cachedPlayersTable cached_players 表中有一行 id 为 1 的数据
+var cachedPlayersTable = []string{
+	"CREATE TABLE cached_players (id INTEGER PRIMARY KEY, name TEXT)",
+	"INSERT INTO cached_players (id, name) VALUES (1, 'a')",
+}
+
+func TestCacheAside(t *testing.T) {
+	dbManager, mr := newMemoryTestManager(t, cachedPlayersTable...)
+	cache := NewCacheAside[cachedPlayer](dbManager, "default", "default", &CacheOptions{Prefix: "player:"})
+	ctx := context.Background()
+
+	var loads atomic.Int32
+	load := func(ctx context.Context, db *gorm.DB, key string) (cachedPlayer, error) {
+		loads.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		var p cachedPlayer
+		err := db.Where("id = ?", key).First(&p).Error
+		return p, err
+	}
+
+	// 并发未命中只加载一次
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if p, err := cache.Get(ctx, "1", load); err != nil || p.Name != "a" {
+				t.Errorf("get: %+v %v", p, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := loads.Load(); n != 1 {
+		t.Errorf("loaded %d times, want 1", n)
+	}
+	if ttl := mr.TTL("player:1"); ttl < defaultCacheTTL || ttl >= defaultCacheTTL+defaultCacheTTL/10 {
+		t.Errorf("ttl = %v", ttl)
+	}
+
+	// 不存在的记录缓存空值
+	for i := 0; i < 2; i++ {
+		if _, err := cache.Get(ctx, "2", load); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Fatalf("get missing: %v", err)
+		}
+	}
+	if n := loads.Load(); n != 2 {
+		t.Errorf("loaded %d times, want 2", n)
+	}
+
+	if err := cache.Invalidate(ctx, "1", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists("player:1") || mr.Exists("player:2") {
+		t.Error("keys should be invalidated")
+	}
+}
+
+func TestCacheAsideCancel(t *testing.T) {
+	dbManager, mr := newMemoryTestManager(t, cachedPlayersTable...)
+	cache := NewCacheAside[cachedPlayer](dbManager, "default", "default", &CacheOptions{Prefix: "player:"})
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	load := func(ctx context.Context, db *gorm.DB, key string) (cachedPlayer, error) {
+		close(started)
+		<-release
+		var p cachedPlayer
+		err := db.WithContext(ctx).Where("id = ?", key).First(&p).Error
+		return p, err
+	}
+
+	// 第一个调用方取消后，等待同一个 key 的其他调用方仍然拿到结果
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := cache.Get(ctx, "1", load)
+		first <- err
+	}()
+	<-started
+	second := make(chan error, 1)
+	go func() {
+		p, err := cache.Get(context.Background(), "1", load)
+		if err == nil && p.Name != "a" {
+			err = errors.New("wrong player " + p.Name)
+		}
+		second <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("first: %v", err)
+	}
+	close(release)
+	if err := <-second; err != nil {
+		t.Errorf("second: %v", err)
+	}
+	if !mr.Exists("player:1") {
+		t.Error("cache should be filled")
+	}
+}
+
+func TestCacheAsideInTx(t *testing.T) {
+	dbManager, mr := newMemoryTestManager(t, cachedPlayersTable...)
+	cache := NewCacheAside[cachedPlayer](dbManager, "default", "default", &CacheOptions{Prefix: "player:"})
+	load := func(ctx context.Context, db *gorm.DB, key string) (cachedPlayer, error) {
+		var p cachedPlayer
+		err := db.Where("id = ?", key).First(&p).Error
+		return p, err
+	}
+
+	// 事务中读到未提交的数据，不写入缓存
+	errRollback := errors.New("rollback")
+	err := dbManager.WithTx(context.Background(), "default", nil, func(ctx context.Context, tx *gorm.DB) error {
+		if err := tx.Exec("UPDATE cached_players SET name = 'b' WHERE id = 1").Error; err != nil {
+			return err
+		}
+		p, err := cache.Get(ctx, "1", load)
+		if err != nil || p.Name != "b" {
+			t.Errorf("get in tx: %+v %v", p, err)
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatal(err)
+	}
+	if mr.Exists("player:1") {
+		t.Error("uncommitted value should not be cached")
+	}
+	if p, err := cache.Get(context.Background(), "1", load); err != nil || p.Name != "a" {
+		t.Errorf("get: %+v %v", p, err)
+	}
+}
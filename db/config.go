@@ -0,0 +1,145 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 支持的数据源类型
+const (
+	TypeMysql = "mysql"
+	TypePgsql = "pgsql"
+	TypeRedis = "redis"
+
+	TypeRedisCluster  = "redis-cluster"
+	TypeRedisSentinel = "redis-sentinel"
+	TypeRedisRing     = "redis-ring"
+)
+
+func isRedisType(dbType string) bool {
+	switch dbType {
+	case TypeRedis, TypeRedisCluster, TypeRedisSentinel, TypeRedisRing:
+		return true
+	}
+	return false
+}
+
+// Config 数据源配置文件，yaml 和 json 格式均可（json 是 yaml 的子集）
+//
+//	sources:
+//	  - name: default
+//	    type: mysql
+//	    dsn: "user:pass@tcp(127.0.0.1:3306)/game?parseTime=true"
+//	    max_open_conns: 100
+//	    conn_max_lifetime: 30m
+//	    log_level: warn
+//	    replicas:
+//	      - dsn: "user:pass@tcp(127.0.0.2:3306)/game?parseTime=true"
+//	        weight: 2
+//	  - name: default
+//	    type: redis
+//	    dsn: "redis://localhost:6379/0"
+type Config struct {
+	Sources []SourceConfig `yaml:"sources"`
+}
+
+// SourceConfig 单个数据源的配置，未填写的字段使用驱动默认值
+type SourceConfig struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"` // mysql / pgsql / redis / redis-cluster / redis-sentinel / redis-ring
+	DSN  string `yaml:"dsn"`
+
+	// 从库，只对 mysql/pgsql 生效，读请求路由到从库，写请求和事务走主库
+	Replicas []ReplicaConfig `yaml:"replicas"`
+
+	// 连接池
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+
+	// 超时，pgsql 只支持 ConnectTimeout
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	ReadTimeout    time.Duration `yaml:"read_timeout"`
+	WriteTimeout   time.Duration `yaml:"write_timeout"`
+
+	// 启动 ping 失败后的重试次数和首次退避时间
+	PingRetries int           `yaml:"ping_retries"`
+	PingBackoff time.Duration `yaml:"ping_backoff"`
+
+	// gorm 日志级别：silent / error / warn / info，默认 warn
+	LogLevel string `yaml:"log_level"`
+	// 慢查询阈值，超过后以 warn 级别记录，默认 100ms
+	SlowThreshold time.Duration `yaml:"slow_threshold"`
+	// 不把 record not found 当作错误记录
+	IgnoreRecordNotFound bool `yaml:"ignore_record_not_found"`
+	// 需要脱敏的字段，日志中这些字段的参数显示为 ***
+	RedactColumns []string `yaml:"redact_columns"`
+	// info 级别下相同结构的语句在间隔内只记录一次，错误和慢查询不受影响，0 表示不采样
+	SampleInterval time.Duration `yaml:"sample_interval"`
+
+	// 开启 opentelemetry 的 span 和耗时指标，使用全局的 TracerProvider 和 MeterProvider
+	Telemetry bool `yaml:"telemetry"`
+}
+
+// LoadConfig 从文件读取数据源配置
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data)
+}
+
+// ParseConfig 解析 yaml/json 格式的数据源配置
+func ParseConfig(data []byte) (*Config, error) {
+	cfg := &Config{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parse db config: %w", err)
+	}
+	return cfg, nil
+}
+
+// LoadFromConfig 按配置初始化所有数据源
+// 单个数据源失败不会中断其它数据源的初始化，所有错误合并后返回
+func (dbManager *DBManager) LoadFromConfig(cfg *Config) error {
+	sources, errs := validSources(cfg)
+	for _, source := range sources {
+		if err := dbManager.initSource(source); err != nil {
+			errs = append(errs, fmt.Errorf("source %s: %w", source.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validSources 过滤掉没有名字和重复的数据源
+func validSources(cfg *Config) ([]SourceConfig, []error) {
+	if cfg == nil {
+		return nil, []error{errors.New("db config is nil")}
+	}
+
+	var (
+		sources []SourceConfig
+		errs    []error
+		seen    = make(map[string]bool)
+	)
+	for _, source := range cfg.Sources {
+		if source.Name == "" {
+			errs = append(errs, fmt.Errorf("source of type %q: name is empty", source.Type))
+			continue
+		}
+
+		key := sourceKey(source.Type, source.Name)
+		if seen[key] {
+			errs = append(errs, fmt.Errorf("source %s: duplicate source", source.Name))
+			continue
+		}
+		seen[key] = true
+		sources = append(sources, source)
+	}
+	return sources, errs
+}
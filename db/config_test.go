@@ -0,0 +1,54 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	yamlData := `
+sources:
+  - name: default
+    type: mysql
+    dsn: "root:123456@tcp(127.0.0.1:3306)/game"
+    max_open_conns: 50
+    conn_max_lifetime: 30m
+    log_level: info
+`
+	jsonData := `{"sources": [{"name": "default", "type": "mysql", "dsn": "root:123456@tcp(127.0.0.1:3306)/game",
+		"max_open_conns": 50, "conn_max_lifetime": "30m", "log_level": "info"}]}`
+
+	for _, data := range []string{yamlData, jsonData} {
+		cfg, err := ParseConfig([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cfg.Sources) != 1 {
+			t.Fatalf("sources = %d, want 1", len(cfg.Sources))
+		}
+		source := cfg.Sources[0]
+		if source.Name != "default" || source.Type != TypeMysql || source.MaxOpenConns != 50 {
+			t.Errorf("unexpected source: %+v", source)
+		}
+		if source.ConnMaxLifetime != 30*time.Minute {
+			t.Errorf("conn_max_lifetime = %v, want 30m", source.ConnMaxLifetime)
+		}
+	}
+}
+
+func TestLoadFromConfigErrors(t *testing.T) {
+	cfg := &Config{Sources: []SourceConfig{
+		{Name: "", Type: TypeMysql},
+		{Name: "a", Type: "oracle"},
+		{Name: "b", Type: TypeMysql, LogLevel: "verbose"},
+	}}
+
+	err := NewDBManager().LoadFromConfig(cfg)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	// 三个错误都要被收集
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 3 {
+		t.Errorf("errors = %d, want 3: %v", n, err)
+	}
+}
@@ -1,84 +1,289 @@
 package db
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
 	_ "time/tzdata" // 导入时区数据
 
-	"cn.qingdou.server/common/utils"
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
 	"moul.io/zapgorm2"
 )
 
 type DBManager struct {
-	dbMap    map[string]*gorm.DB      //关系型数据库的操作
-	redisMap map[string]*redis.Client //redis数据库的操作
+	mu           sync.RWMutex
+	dbMap        map[string]*gorm.DB              //关系型数据库的操作
+	redisMap     map[string]redis.UniversalClient //redis数据库的操作，单节点、集群、哨兵、分片
+	configs      map[string]SourceConfig          //数据源的配置，热更新时使用，key 见 sourceKey
+	shardGroups  map[string]ShardStrategy         //分片组
+	drainTimeout time.Duration                    //热更新后旧连接延迟关闭的时间
 }
 
+const defaultDrainTimeout = 30 * time.Second
+
 func NewDBManager() *DBManager {
 	return &DBManager{
-		dbMap:    make(map[string]*gorm.DB),
-		redisMap: make(map[string]*redis.Client),
+		dbMap:        make(map[string]*gorm.DB),
+		redisMap:     make(map[string]redis.UniversalClient),
+		configs:      make(map[string]SourceConfig),
+		shardGroups:  make(map[string]ShardStrategy),
+		drainTimeout: defaultDrainTimeout,
 	}
 }
 
+// SetDrainTimeout 设置热更新后旧连接延迟关闭的时间，默认 30 秒
+func (dbManager *DBManager) SetDrainTimeout(d time.Duration) {
+	dbManager.mu.Lock()
+	defer dbManager.mu.Unlock()
+	dbManager.drainTimeout = d
+}
+
 func (dbManager *DBManager) Init(name string, dbType string, dsn string) error {
-	logger := zapgorm2.New(zap.L())
-	logger.SetAsDefault()
-
-	var dialector gorm.Dialector = nil
-
-	switch dbType {
-	case "mysql":
-		dialector = mysql.Open(dsn)
-	case "pgsql":
-		dialector = postgres.Open(dsn)
-	case "redis":
-		client, err := utils.InitRedisByDNS(dsn)
-		if err != nil {
-			return err
-		}
-		dbManager.redisMap[name] = client
-	default:
-		panic("unsuport sql drive")
+	return dbManager.InitWithOptions(name, dbType, dsn)
+}
+
+// InitWithOptions 初始化数据源，可配置连接池、超时以及启动 ping 的重试
+func (dbManager *DBManager) InitWithOptions(name string, dbType string, dsn string, opts ...Option) error {
+	cfg := SourceConfig{Name: name, Type: dbType, DSN: dsn}
+	for _, opt := range opts {
+		opt(&cfg)
 	}
-	db, err := gorm.Open(dialector, &gorm.Config{Logger: logger})
+	return dbManager.initSource(cfg)
+}
 
+// initSource 建立新连接并验证通过后再替换，同名的旧连接延迟关闭
+func (dbManager *DBManager) initSource(cfg SourceConfig) error {
+	var (
+		db     *gorm.DB
+		client redis.UniversalClient
+		err    error
+	)
+	switch {
+	case cfg.Type == TypeMysql || cfg.Type == TypePgsql:
+		db, err = openGorm(cfg)
+	case isRedisType(cfg.Type):
+		client, err = openRedis(cfg)
+	default:
+		err = fmt.Errorf("unsupported db type: %s", cfg.Type)
+	}
 	if err != nil {
 		return err
 	}
 
-	dbManager.dbMap[name] = db
+	dbManager.mu.Lock()
+	oldDB, oldClient := dbManager.dbMap[cfg.Name], dbManager.redisMap[cfg.Name]
+	if db != nil {
+		dbManager.dbMap[cfg.Name] = db
+		oldClient = nil
+	} else {
+		dbManager.redisMap[cfg.Name] = client
+		oldDB = nil
+	}
+	dbManager.configs[sourceKey(cfg.Type, cfg.Name)] = cfg
+	drainTimeout := dbManager.drainTimeout
+	dbManager.mu.Unlock()
+
+	if oldDB != nil || oldClient != nil {
+		drain(cfg.Name, oldDB, oldClient, drainTimeout)
+	}
 	return nil
 }
 
-func (dbManager *DBManager) GetGorm(name string) *gorm.DB {
-	if _, ok := dbManager.dbMap[name]; ok {
-		return dbManager.dbMap[name]
+// sourceKey 关系型数据库和 redis 分开存放，同名不冲突
+func sourceKey(dbType string, name string) string {
+	if isRedisType(dbType) {
+		return "redis/" + name
 	}
-	return nil
+	return "sql/" + name
+}
+
+var setDefaultGormLogger sync.Once
+
+func openGorm(cfg SourceConfig) (*gorm.DB, error) {
+	level, err := parseLogLevel(cfg.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	// 未通过 DBManager 打开的 gorm 也使用 zap 输出日志，只在第一次打开时设置，之后替换 zap 全局 logger 不会更新
+	setDefaultGormLogger.Do(func() { zapgorm2.New(zap.L()).SetAsDefault() })
+	logger := newSQLLogger(cfg, level)
+
+	dialector, err := openDialector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// 自动 ping 关掉，由 pingWithRetry 负责，避免数据库短暂不可用时启动直接失败
+	db, err := gorm.Open(dialector, &gorm.Config{Logger: logger, DisableAutomaticPing: true})
+	if err != nil {
+		return nil, err
+	}
+
+	// 连接池配置，0 表示使用 database/sql 的默认值
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+	if cfg.ConnMaxIdleTime > 0 {
+		sqlDB.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	}
+
+	if err := pingWithRetry(cfg, sqlDB.PingContext); err != nil {
+		sqlDB.Close()
+		return nil, fmt.Errorf("failed to connect %s: %w", cfg.Type, err)
+	}
+
+	if len(cfg.Replicas) > 0 {
+		if err := useReplicas(db, cfg); err != nil {
+			closeSource(db, nil)
+			return nil, err
+		}
+	}
+
+	if cfg.Telemetry {
+		plugin, err := newGormTelemetry(cfg.Name, db.Dialector.Name())
+		if err == nil {
+			err = db.Use(plugin)
+		}
+		if err != nil {
+			closeSource(db, nil)
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// openDialector 主库和从库的 gorm.Dialector，测试中替换为 sqlite
+var openDialector = newDialector
+
+func newDialector(cfg SourceConfig) (gorm.Dialector, error) {
+	switch cfg.Type {
+	case TypeMysql:
+		dsnConfig, err := mysqldriver.ParseDSN(cfg.DSN)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.ConnectTimeout > 0 {
+			dsnConfig.Timeout = cfg.ConnectTimeout
+		}
+		if cfg.ReadTimeout > 0 {
+			dsnConfig.ReadTimeout = cfg.ReadTimeout
+		}
+		if cfg.WriteTimeout > 0 {
+			dsnConfig.WriteTimeout = cfg.WriteTimeout
+		}
+		return mysql.New(mysql.Config{DSNConfig: dsnConfig}), nil
+	case TypePgsql:
+		if cfg.ConnectTimeout <= 0 {
+			return postgres.Open(cfg.DSN), nil
+		}
+		connConfig, err := pgx.ParseConfig(cfg.DSN)
+		if err != nil {
+			return nil, err
+		}
+		connConfig.ConnectTimeout = cfg.ConnectTimeout
+		return postgres.New(postgres.Config{Conn: stdlib.OpenDB(*connConfig)}), nil
+	}
+	return nil, fmt.Errorf("unsupported sql driver: %s", cfg.Type)
+}
+
+func parseLogLevel(level string) (gormlogger.LogLevel, error) {
+	switch strings.ToLower(level) {
+	case "":
+		return gormlogger.Warn, nil
+	case "silent":
+		return gormlogger.Silent, nil
+	case "error":
+		return gormlogger.Error, nil
+	case "warn":
+		return gormlogger.Warn, nil
+	case "info":
+		return gormlogger.Info, nil
+	}
+	return 0, fmt.Errorf("unknown log level: %s", level)
+}
+
+func (dbManager *DBManager) GetGorm(name string) *gorm.DB {
+	dbManager.mu.RLock()
+	defer dbManager.mu.RUnlock()
+	return dbManager.dbMap[name]
 }
 
 func (dbManager *DBManager) GetDefaultGorm() *gorm.DB {
 	return dbManager.GetGorm("default")
 }
 
+// GetRedisClient 获取单节点或哨兵模式的客户端，集群和分片模式返回 nil，请使用 GetUniversalRedis
 func (dbManager *DBManager) GetRedisClient(name string) *redis.Client {
-	if _, ok := dbManager.redisMap[name]; ok {
-		return dbManager.redisMap[name]
-	}
-	return nil
+	client, _ := dbManager.GetUniversalRedis(name).(*redis.Client)
+	return client
 }
 
 func (dbManager *DBManager) GetDefaultRedis() *redis.Client {
 	return dbManager.GetRedisClient("default")
 }
 
-func (dbManager *DBManager) Close() {
-	for _, db := range dbManager.dbMap {
-		sqlDB, _ := db.DB()
-		sqlDB.Close()
+// GetUniversalRedis 获取任意模式的 redis 客户端
+func (dbManager *DBManager) GetUniversalRedis(name string) redis.UniversalClient {
+	dbManager.mu.RLock()
+	defer dbManager.mu.RUnlock()
+	return dbManager.redisMap[name]
+}
+
+func (dbManager *DBManager) GetDefaultUniversalRedis() redis.UniversalClient {
+	return dbManager.GetUniversalRedis("default")
+}
+
+// Close 关闭所有数据源（包括从库和 redis），返回关闭过程中的所有错误
+func (dbManager *DBManager) Close() error {
+	dbManager.mu.Lock()
+	defer dbManager.mu.Unlock()
+
+	var errs []error
+	for name, db := range dbManager.dbMap {
+		if err := closeSource(db, nil); err != nil {
+			errs = append(errs, fmt.Errorf("close %s: %w", name, err))
+		}
+	}
+	for name, client := range dbManager.redisMap {
+		if err := closeSource(nil, client); err != nil {
+			errs = append(errs, fmt.Errorf("close redis %s: %w", name, err))
+		}
+	}
+
+	dbManager.dbMap = make(map[string]*gorm.DB)
+	dbManager.redisMap = make(map[string]redis.UniversalClient)
+	dbManager.configs = make(map[string]SourceConfig)
+	return errors.Join(errs...)
+}
+
+func closeSource(db *gorm.DB, client redis.UniversalClient) error {
+	var errs []error
+	if db != nil {
+		for _, sqlDB := range sqlDBs(db) {
+			errs = append(errs, sqlDB.Close())
+		}
+	}
+	if client != nil {
+		errs = append(errs, client.Close())
 	}
+	return errors.Join(errs...)
 }
@@ -3,7 +3,7 @@ package db
 import (
 	"testing"
 
-	"cn.qingdou.server/common/utils"
+	"github.com/zuodazuoqianggame/common/utils"
 )
 
 func TestRedis(t *testing.T) {
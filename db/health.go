@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultHealthTimeout = 3 * time.Second
+
+// SourceStatus 单个连接池的健康状态
+type SourceStatus struct {
+	Name    string        `json:"name"`
+	Type    string        `json:"type"` // 配置中的类型：mysql / pgsql / redis / redis-cluster ...
+	Role    string        `json:"role"` // primary，从库为 replica-1、replica-2 ...
+	Healthy bool          `json:"healthy"`
+	Latency time.Duration `json:"latency"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// Health 并发 ping 所有数据源（包括从库），ctx 没有截止时间时默认 3 秒超时
+func (dbManager *DBManager) Health(ctx context.Context) []SourceStatus {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultHealthTimeout)
+		defer cancel()
+	}
+
+	// 先在锁内取出所有连接，ping 的时候不持有锁，避免阻塞热更新和 GetGorm
+	type target struct {
+		status SourceStatus
+		index  int // 0 为主库，从库从 1 开始，按数字排序
+		ping   func(ctx context.Context) error
+	}
+	var targets []target
+	dbManager.mu.RLock()
+	for name, db := range dbManager.dbMap {
+		dbType := dbManager.configs[sourceKey(TypeMysql, name)].Type
+		for i, sqlDB := range sqlDBs(db) {
+			role := "primary"
+			if i > 0 {
+				role = fmt.Sprintf("replica-%d", i)
+			}
+			targets = append(targets, target{SourceStatus{Name: name, Type: dbType, Role: role}, i, sqlDB.PingContext})
+		}
+	}
+	for name, client := range dbManager.redisMap {
+		dbType := dbManager.configs[sourceKey(TypeRedis, name)].Type
+		targets = append(targets, target{SourceStatus{Name: name, Type: dbType, Role: "primary"}, 0, func(ctx context.Context) error {
+			return client.Ping(ctx).Err()
+		}})
+	}
+	dbManager.mu.RUnlock()
+
+	sort.Slice(targets, func(i, j int) bool {
+		a, b := targets[i], targets[j]
+		if a.status.Name != b.status.Name {
+			return a.status.Name < b.status.Name
+		}
+		if a.status.Type != b.status.Type {
+			return a.status.Type < b.status.Type
+		}
+		return a.index < b.index
+	})
+
+	var wg sync.WaitGroup
+	statuses := make([]SourceStatus, len(targets))
+	for i, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			status := t.status
+			begin := time.Now()
+			err := t.ping(ctx)
+			status.Latency = time.Since(begin)
+			status.Healthy = err == nil
+			if err != nil {
+				status.Error = err.Error()
+			}
+			statuses[i] = status
+		}()
+	}
+	wg.Wait()
+	return statuses
+}
+
+// Ready 所有数据源都健康时返回 nil，可直接用于 kratos 的 BeforeStart 等生命周期钩子
+func (dbManager *DBManager) Ready(ctx context.Context) error {
+	var errs []error
+	for _, status := range dbManager.Health(ctx) {
+		if !status.Healthy {
+			errs = append(errs, fmt.Errorf("%s %s(%s): %s", status.Type, status.Name, status.Role, status.Error))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// HealthHandler 用于 k8s readiness 探针，全部健康返回 200，否则返回 503，body 为各数据源状态
+func (dbManager *DBManager) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		statuses := dbManager.Health(r.Context())
+
+		code := http.StatusOK
+		for _, status := range statuses {
+			if !status.Healthy {
+				code = http.StatusServiceUnavailable
+				break
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(statuses)
+	})
+}
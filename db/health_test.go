@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestHealth(t *testing.T) {
+	dbManager := newReplicaTestManager(t, ReplicaConfig{DSN: "replica"})
+	mr := miniredis.RunT(t)
+	if err := dbManager.initSource(SourceConfig{Name: "default", Type: TypeRedis, DSN: "redis://" + mr.Addr()}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	want := []SourceStatus{
+		{Name: "default", Type: TypeMysql, Role: "primary"},
+		{Name: "default", Type: TypeMysql, Role: "replica-1"},
+		{Name: "default", Type: TypeRedis, Role: "primary"},
+	}
+	statuses := dbManager.Health(ctx)
+	if len(statuses) != len(want) {
+		t.Fatalf("statuses = %+v", statuses)
+	}
+	for i, status := range statuses {
+		if status.Name != want[i].Name || status.Type != want[i].Type || status.Role != want[i].Role || !status.Healthy {
+			t.Errorf("status %d = %+v, want %+v", i, status, want[i])
+		}
+	}
+	if err := dbManager.Ready(ctx); err != nil {
+		t.Errorf("ready: %v", err)
+	}
+
+	handler := dbManager.HealthHandler()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("code = %d, want 200", rec.Code)
+	}
+
+	// redis 不可用时探针返回 503，body 中标记出故障的数据源
+	mr.Close()
+	if err := dbManager.Ready(ctx); err == nil {
+		t.Error("ready should fail when redis is down")
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("code = %d, want 503", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("content type = %s", ct)
+	}
+	var body []SourceStatus
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range body {
+		if down := status.Type == TypeRedis; status.Healthy == down || (down && status.Error == "") {
+			t.Errorf("status = %+v", status)
+		}
+	}
+}
+
+func TestHealthReplicaOrder(t *testing.T) {
+	replicas := make([]ReplicaConfig, 11)
+	for i := range replicas {
+		replicas[i].DSN = fmt.Sprintf("replica_%d", i)
+	}
+	dbManager := newReplicaTestManager(t, replicas...)
+
+	// 从库按序号排序，replica-10 排在 replica-2 之后
+	statuses := dbManager.Health(context.Background())
+	if len(statuses) != 12 || statuses[0].Role != "primary" {
+		t.Fatalf("statuses = %+v", statuses)
+	}
+	for i, status := range statuses[1:] {
+		if want := fmt.Sprintf("replica-%d", i+1); status.Role != want {
+			t.Errorf("status %d role = %s, want %s", i+1, status.Role, want)
+		}
+	}
+}
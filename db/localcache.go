@@ -0,0 +1,480 @@
+package db
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+// TwoLevelOptions 两级缓存选项，nil 时使用默认值
+type TwoLevelOptions struct {
+	Prefix      string        // redis key 前缀
+	Channel     string        // 失效通知的频道，默认 common:cache:invalidate
+	MaxEntries  int           // 本地最多缓存的条数，超出后淘汰最久未使用的，默认 10000
+	LocalTTL    time.Duration // 本地缓存时间，也是丢失失效通知时的最长不一致时间，默认 1 分钟
+	RedisTTL    time.Duration // redis 缓存时间，默认 10 分钟，小于 0 不过期
+	LoadTimeout time.Duration // 未命中时加载的超时时间，默认 5 秒
+	Codec       Codec         // 默认 JSONCodec
+}
+
+const (
+	defaultInvalidateChannel = "common:cache:invalidate"
+	defaultLocalMaxEntries   = 10000
+	defaultLocalTTL          = time.Minute
+
+	// 订阅断开后重新订阅的退避时间
+	resubscribeMinBackoff = 100 * time.Millisecond
+	resubscribeMaxBackoff = 5 * time.Second
+)
+
+// CacheStats 缓存命中统计
+type CacheStats struct {
+	LocalHits   int64 `json:"local_hits"`
+	LocalMisses int64 `json:"local_misses"`
+	RedisHits   int64 `json:"redis_hits"`
+	RedisMisses int64 `json:"redis_misses"`
+	Evictions   int64 `json:"evictions"`
+	Size        int   `json:"size"`
+}
+
+// invalidateMessage 失效通知，from 为发送方实例，收到自己发送的通知时忽略
+type invalidateMessage struct {
+	From string   `json:"from"`
+	Keys []string `json:"keys"`
+}
+
+// TwoLevelCache 进程内 LRU 缓存 + redis 的两级缓存，写入和删除时通过 redis pub/sub
+// 通知所有实例删除本地缓存，适合道具表、商店配置等读多写少的热点数据
+type TwoLevelCache[T any] struct {
+	dbManager *DBManager
+	redisName string
+	opts      TwoLevelOptions
+	id        string
+	local     *lruCache[T]
+	group     singleflight.Group
+
+	mu     sync.Mutex
+	pubsub *redis.PubSub
+	done   chan struct{}
+
+	localHits, localMisses, redisHits, redisMisses atomic.Int64
+}
+
+// NewTwoLevelCache 使用 DBManager 中名为 redisName 的 redis 创建两级缓存并订阅失效通知，不再使用时调用 Close
+func NewTwoLevelCache[T any](ctx context.Context, dbManager *DBManager, redisName string, opts *TwoLevelOptions) (*TwoLevelCache[T], error) {
+	var o TwoLevelOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Channel == "" {
+		o.Channel = defaultInvalidateChannel
+	}
+	if o.MaxEntries <= 0 {
+		o.MaxEntries = defaultLocalMaxEntries
+	}
+	if o.LocalTTL <= 0 {
+		o.LocalTTL = defaultLocalTTL
+	}
+	if o.RedisTTL == 0 {
+		o.RedisTTL = defaultCacheTTL
+	} else if o.RedisTTL < 0 {
+		o.RedisTTL = 0
+	}
+	if o.LoadTimeout <= 0 {
+		o.LoadTimeout = defaultCacheLoadTimeout
+	}
+	if o.Codec == nil {
+		o.Codec = JSONCodec{}
+	}
+
+	c := &TwoLevelCache[T]{
+		dbManager: dbManager,
+		redisName: redisName,
+		opts:      o,
+		id:        fmt.Sprintf("%016x", rand.Uint64()),
+		local:     newLRUCache[T](o.MaxEntries, o.LocalTTL),
+		done:      make(chan struct{}),
+	}
+
+	pubsub, err := c.subscribe(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.pubsub = pubsub
+	go c.listen(pubsub)
+	return c, nil
+}
+
+// subscribe 每次从 DBManager 获取 redis，热更新后订阅到新连接上
+func (c *TwoLevelCache[T]) subscribe(ctx context.Context) (*redis.PubSub, error) {
+	client := c.dbManager.GetUniversalRedis(c.redisName)
+	if client == nil {
+		return nil, fmt.Errorf("redis %s not found", c.redisName)
+	}
+	pubsub := client.Subscribe(ctx, c.opts.Channel)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("subscribe %s: %w", c.opts.Channel, err)
+	}
+	return pubsub, nil
+}
+
+// listen 处理其他实例发来的失效通知，断线重连期间丢失的通知依赖 LocalTTL 兜底。
+// Reload 关闭旧的 redis 后订阅会结束，此时重新订阅直到 Close
+func (c *TwoLevelCache[T]) listen(pubsub *redis.PubSub) {
+	for {
+		c.consume(pubsub.Channel())
+		if pubsub = c.resubscribe(); pubsub == nil {
+			return
+		}
+		// 订阅中断期间的通知已经丢失
+		c.local.clear()
+	}
+}
+
+// resubscribe 按退避时间重新订阅，Close 后返回 nil
+func (c *TwoLevelCache[T]) resubscribe() *redis.PubSub {
+	backoff := resubscribeMinBackoff
+	for {
+		select {
+		case <-c.done:
+			return nil
+		default:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), resubscribeMaxBackoff)
+		pubsub, err := c.subscribe(ctx)
+		cancel()
+		if err == nil {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			select {
+			case <-c.done:
+				pubsub.Close()
+				return nil
+			default:
+			}
+			c.pubsub = pubsub
+			zap.L().Info("cache invalidate resubscribed", zap.String("channel", c.opts.Channel))
+			return pubsub
+		}
+		zap.L().Warn("cache invalidate resubscribe failed", zap.String("channel", c.opts.Channel), zap.Error(err))
+
+		select {
+		case <-c.done:
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, resubscribeMaxBackoff)
+	}
+}
+
+func (c *TwoLevelCache[T]) consume(ch <-chan *redis.Message) {
+	for msg := range ch {
+		var m invalidateMessage
+		if err := json.Unmarshal([]byte(msg.Payload), &m); err != nil {
+			zap.L().Warn("invalid cache invalidate message", zap.String("payload", msg.Payload), zap.Error(err))
+			continue
+		}
+		if m.From == c.id {
+			continue
+		}
+		for _, key := range m.Keys {
+			// 同一频道上可能有多个不同前缀的缓存
+			if k, ok := strings.CutPrefix(key, c.opts.Prefix); ok {
+				c.local.remove(k)
+			}
+		}
+	}
+}
+
+// Get 依次读取本地缓存、redis，都未命中时调用 load 加载并写入两级缓存，
+// 同一个 key 并发未命中时只加载一次，load 返回的错误原样返回
+func (c *TwoLevelCache[T]) Get(ctx context.Context, key string, load func(ctx context.Context, key string) (T, error)) (T, error) {
+	var zero T
+	if value, ok := c.local.get(key); ok {
+		c.localHits.Add(1)
+		return value, nil
+	}
+	c.localMisses.Add(1)
+
+	// 加载结果由所有等待的调用方共享，不能因为第一个调用方取消而失败
+	ch := c.group.DoChan(key, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.opts.LoadTimeout)
+		defer cancel()
+
+		client := c.dbManager.GetUniversalRedis(c.redisName)
+		if client == nil {
+			return zero, fmt.Errorf("redis %s not found", c.redisName)
+		}
+
+		data, err := client.Get(ctx, c.opts.Prefix+key).Bytes()
+		if err == nil {
+			var value T
+			if err = c.opts.Codec.Unmarshal(data, &value); err == nil {
+				c.redisHits.Add(1)
+				c.local.set(key, value)
+				return value, nil
+			}
+		}
+		if !errors.Is(err, redis.Nil) {
+			zap.L().Warn("cache get failed", zap.String("key", c.opts.Prefix+key), zap.Error(err))
+		}
+		c.redisMisses.Add(1)
+
+		value, err := load(ctx, key)
+		if err != nil {
+			return zero, err
+		}
+		if err := c.setRedis(ctx, client, key, value); err != nil {
+			zap.L().Warn("cache set failed", zap.String("key", c.opts.Prefix+key), zap.Error(err))
+		}
+		c.local.set(key, value)
+		return value, nil
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return zero, res.Err
+		}
+		return res.Val.(T), nil
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
+
+// Set 写入两级缓存并通知其他实例删除本地缓存
+func (c *TwoLevelCache[T]) Set(ctx context.Context, key string, value T) error {
+	client := c.dbManager.GetUniversalRedis(c.redisName)
+	if client == nil {
+		return fmt.Errorf("redis %s not found", c.redisName)
+	}
+	if err := c.setRedis(ctx, client, key, value); err != nil {
+		return err
+	}
+	c.local.set(key, value)
+	return c.publish(ctx, client, key)
+}
+
+// Invalidate 删除两级缓存并通知其他实例删除本地缓存
+func (c *TwoLevelCache[T]) Invalidate(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	client := c.dbManager.GetUniversalRedis(c.redisName)
+	if client == nil {
+		return fmt.Errorf("redis %s not found", c.redisName)
+	}
+
+	for _, key := range keys {
+		c.local.remove(key)
+	}
+	pipe := client.Pipeline()
+	for _, key := range keys {
+		pipe.Del(ctx, c.opts.Prefix+key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	return c.publish(ctx, client, keys...)
+}
+
+// Warm 批量预热，先从 redis 读取，缺失的 key 调用 load 批量加载后写入两级缓存，
+// load 返回的结果中没有的 key 视为不存在
+func (c *TwoLevelCache[T]) Warm(ctx context.Context, keys []string, load func(ctx context.Context, keys []string) (map[string]T, error)) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	client := c.dbManager.GetUniversalRedis(c.redisName)
+	if client == nil {
+		return fmt.Errorf("redis %s not found", c.redisName)
+	}
+
+	// 集群模式下 MGET 要求 key 在同一个 slot，用 pipeline 逐个读取
+	pipe := client.Pipeline()
+	cmds := make([]*redis.StringCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Get(ctx, c.opts.Prefix+key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+
+	var missing []string
+	for i, cmd := range cmds {
+		data, err := cmd.Bytes()
+		var value T
+		if err == nil {
+			err = c.opts.Codec.Unmarshal(data, &value)
+		}
+		if err != nil {
+			missing = append(missing, keys[i])
+			continue
+		}
+		c.local.set(keys[i], value)
+	}
+	if len(missing) == 0 || load == nil {
+		return nil
+	}
+
+	values, err := load(ctx, missing)
+	if err != nil {
+		return err
+	}
+	pipe = client.Pipeline()
+	for key, value := range values {
+		data, err := c.opts.Codec.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("encode %s: %w", key, err)
+		}
+		pipe.Set(ctx, c.opts.Prefix+key, data, c.opts.RedisTTL)
+		c.local.set(key, value)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// Stats 获取命中统计
+func (c *TwoLevelCache[T]) Stats() CacheStats {
+	size, evictions := c.local.stats()
+	return CacheStats{
+		LocalHits:   c.localHits.Load(),
+		LocalMisses: c.localMisses.Load(),
+		RedisHits:   c.redisHits.Load(),
+		RedisMisses: c.redisMisses.Load(),
+		Evictions:   evictions,
+		Size:        size,
+	}
+}
+
+// Close 取消订阅失效通知
+func (c *TwoLevelCache[T]) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.done:
+		return nil
+	default:
+	}
+	close(c.done)
+	return c.pubsub.Close()
+}
+
+func (c *TwoLevelCache[T]) setRedis(ctx context.Context, client redis.UniversalClient, key string, value T) error {
+	data, err := c.opts.Codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return client.Set(ctx, c.opts.Prefix+key, data, c.opts.RedisTTL).Err()
+}
+
+func (c *TwoLevelCache[T]) publish(ctx context.Context, client redis.UniversalClient, keys ...string) error {
+	fullKeys := make([]string, len(keys))
+	for i, key := range keys {
+		fullKeys[i] = c.opts.Prefix + key
+	}
+	payload, err := json.Marshal(invalidateMessage{From: c.id, Keys: fullKeys})
+	if err != nil {
+		return err
+	}
+	return client.Publish(ctx, c.opts.Channel, payload).Err()
+}
+
+// lruCache 带过期时间的 LRU 缓存
+type lruCache[T any] struct {
+	mu         sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	ll         *list.List
+	items      map[string]*list.Element
+	evictions  int64
+}
+
+type lruEntry[T any] struct {
+	key      string
+	value    T
+	expireAt time.Time
+}
+
+func newLRUCache[T any](maxEntries int, ttl time.Duration) *lruCache[T] {
+	return &lruCache[T]{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+func (c *lruCache[T]) get(key string) (T, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero T
+	elem, ok := c.items[key]
+	if !ok {
+		return zero, false
+	}
+	entry := elem.Value.(*lruEntry[T])
+	if time.Now().After(entry.expireAt) {
+		c.ll.Remove(elem)
+		delete(c.items, key)
+		return zero, false
+	}
+	c.ll.MoveToFront(elem)
+	return entry.value, true
+}
+
+func (c *lruCache[T]) set(key string, value T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expireAt := time.Now().Add(c.ttl)
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*lruEntry[T])
+		entry.value, entry.expireAt = value, expireAt
+		c.ll.MoveToFront(elem)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&lruEntry[T]{key: key, value: value, expireAt: expireAt})
+	for c.ll.Len() > c.maxEntries {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry[T]).key)
+		c.evictions++
+	}
+}
+
+func (c *lruCache[T]) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.ll.Remove(elem)
+		delete(c.items, key)
+	}
+}
+
+func (c *lruCache[T]) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	clear(c.items)
+}
+
+func (c *lruCache[T]) stats() (int, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len(), c.evictions
+}
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache[int](2, time.Minute)
+	c.set("a", 1)
+	c.set("b", 2)
+	c.get("a")
+	c.set("c", 3) // 淘汰最久未使用的 b
+
+	if _, ok := c.get("b"); ok {
+		t.Error("b should be evicted")
+	}
+	if v, ok := c.get("a"); !ok || v != 1 {
+		t.Errorf("a = %v %v", v, ok)
+	}
+	if size, evictions := c.stats(); size != 2 || evictions != 1 {
+		t.Errorf("size = %d, evictions = %d", size, evictions)
+	}
+
+	expired := newLRUCache[int](2, -time.Second)
+	expired.set("a", 1)
+	if _, ok := expired.get("a"); ok {
+		t.Error("a should be expired")
+	}
+}
+
+func TestTwoLevelCacheInvalidate(t *testing.T) {
+	dbManager, _ := newMemoryTestManager(t, cachedPlayersTable...)
+	ctx := context.Background()
+	opts := &TwoLevelOptions{Prefix: "item:"}
+
+	a, err := NewTwoLevelCache[string](ctx, dbManager, "default", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := NewTwoLevelCache[string](ctx, dbManager, "default", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	loads := 0
+	load := func(ctx context.Context, key string) (string, error) {
+		loads++
+		return "v1", nil
+	}
+	if v, err := b.Get(ctx, "1", load); err != nil || v != "v1" {
+		t.Fatalf("get: %v %v", v, err)
+	}
+	if v, _ := a.Get(ctx, "1", load); v != "v1" || loads != 1 {
+		t.Fatalf("a should read from redis, loads = %d", loads)
+	}
+
+	if err := a.Set(ctx, "1", "v2"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if v, _ := b.Get(ctx, "1", load); v == "v2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("b did not receive invalidation")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if v, _ := a.Get(ctx, "1", load); v != "v2" {
+		t.Errorf("a = %v, want v2", v)
+	}
+
+	if err := b.Warm(ctx, []string{"1", "2"}, func(ctx context.Context, keys []string) (map[string]string, error) {
+		if len(keys) != 1 || keys[0] != "2" {
+			t.Errorf("warm keys = %v", keys)
+		}
+		return map[string]string{"2": "w"}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := b.Get(ctx, "2", load); v != "w" {
+		t.Errorf("warmed value = %v", v)
+	}
+	if stats := b.Stats(); stats.LocalHits == 0 || stats.RedisMisses != 1 || stats.Size != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestTwoLevelCacheResubscribe(t *testing.T) {
+	dbManager, mr := newMemoryTestManager(t, cachedPlayersTable...)
+	dbManager.configs[sourceKey(TypeRedis, "default")] = SourceConfig{Type: TypeRedis, Name: "default", DSN: "redis://" + mr.Addr()}
+	dbManager.SetDrainTimeout(0)
+	ctx := context.Background()
+	opts := &TwoLevelOptions{Prefix: "item:"}
+
+	a, err := NewTwoLevelCache[string](ctx, dbManager, "default", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := NewTwoLevelCache[string](ctx, dbManager, "default", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	load := func(ctx context.Context, key string) (string, error) { return "v1", nil }
+	if v, err := b.Get(ctx, "1", load); err != nil || v != "v1" {
+		t.Fatalf("get: %v %v", v, err)
+	}
+
+	// 热更新关闭旧连接后，b 在新连接上重新订阅，继续收到失效通知
+	if err := dbManager.Reload("default", "redis://"+mr.Addr()); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if err := a.Set(ctx, "1", "v2"); err != nil {
+			t.Fatal(err)
+		}
+		if v, _ := b.Get(ctx, "1", load); v == "v2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("b did not resubscribe")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTwoLevelCacheCancel(t *testing.T) {
+	dbManager, _ := newMemoryTestManager(t, cachedPlayersTable...)
+	c, err := NewTwoLevelCache[string](context.Background(), dbManager, "default", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	load := func(ctx context.Context, key string) (string, error) {
+		close(started)
+		<-release
+		return "v", ctx.Err()
+	}
+
+	// 第一个调用方取消不影响共享同一次加载的其他调用方
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := c.Get(ctx, "1", load)
+		first <- err
+	}()
+	<-started
+	second := make(chan error, 1)
+	go func() {
+		_, err := c.Get(context.Background(), "1", load)
+		second <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("first: %v", err)
+	}
+	close(release)
+	if err := <-second; err != nil {
+		t.Errorf("second: %v", err)
+	}
+}
@@ -0,0 +1,262 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"moul.io/zapgorm2"
+)
+
+// sqlLogger 在 zapgorm2 的基础上增加数据源名称和 trace id、敏感参数脱敏以及重复语句采样
+type sqlLogger struct {
+	zapgorm2.Logger
+	redact         map[string]bool
+	sampleInterval time.Duration
+	sampled        *sampledSQL
+}
+
+// sampledSQL 语句指纹上次记录的时间，LogMode 复制出的 logger 共用
+type sampledSQL struct {
+	mu      sync.Mutex
+	last    map[string]int64
+	sweepAt int64
+}
+
+func newSQLLogger(cfg SourceConfig, level gormlogger.LogLevel) *sqlLogger {
+	inner := zapgorm2.New(zap.L())
+	inner.LogLevel = level
+	if cfg.SlowThreshold > 0 {
+		inner.SlowThreshold = cfg.SlowThreshold
+	}
+	inner.IgnoreRecordNotFoundError = cfg.IgnoreRecordNotFound
+	inner.SkipCallerLookup = true // 调用位置由 withCaller 计算
+	source := cfg.Name
+	inner.Context = func(ctx context.Context) []zapcore.Field {
+		fields := []zapcore.Field{zap.String("source", source)}
+		if spanCtx := trace.SpanContextFromContext(ctx); spanCtx.IsValid() {
+			fields = append(fields,
+				zap.String("trace_id", spanCtx.TraceID().String()),
+				zap.String("span_id", spanCtx.SpanID().String()))
+		}
+		return fields
+	}
+
+	l := &sqlLogger{Logger: inner, sampleInterval: cfg.SampleInterval, sampled: &sampledSQL{last: make(map[string]int64)}}
+	if len(cfg.RedactColumns) > 0 {
+		l.redact = make(map[string]bool, len(cfg.RedactColumns))
+		for _, column := range cfg.RedactColumns {
+			l.redact[strings.ToLower(column)] = true
+		}
+	}
+	return l
+}
+
+func (l *sqlLogger) LogMode(level gormlogger.LogLevel) gormlogger.Interface {
+	clone := *l
+	clone.Logger.LogLevel = level
+	return &clone
+}
+
+func (l *sqlLogger) Info(ctx context.Context, str string, args ...interface{}) {
+	l.withCaller().Info(ctx, str, args...)
+}
+
+func (l *sqlLogger) Warn(ctx context.Context, str string, args ...interface{}) {
+	l.withCaller().Warn(ctx, str, args...)
+}
+
+func (l *sqlLogger) Error(ctx context.Context, str string, args ...interface{}) {
+	l.withCaller().Error(ctx, str, args...)
+}
+
+func (l *sqlLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.LogLevel <= gormlogger.Silent {
+		return
+	}
+
+	// 只对正常的 info 级别语句采样，错误和慢查询全部记录
+	normal := (err == nil || (l.IgnoreRecordNotFoundError && errors.Is(err, gorm.ErrRecordNotFound))) &&
+		(l.SlowThreshold == 0 || time.Since(begin) <= l.SlowThreshold)
+	if normal && l.LogLevel >= gormlogger.Info && l.sampleInterval > 0 {
+		sql, rows := fc()
+		if !l.sample(sql) {
+			return
+		}
+		fc = func() (string, int64) { return sql, rows }
+	}
+	l.withCaller().Trace(ctx, begin, fc, err)
+}
+
+// ParamsFilter gorm 生成 SQL 日志前调用，把敏感字段的参数替换成 ***
+func (l *sqlLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	if len(l.redact) == 0 || len(params) == 0 {
+		return sql, params
+	}
+
+	indexes := sensitiveParams(sql, l.redact)
+	if len(indexes) == 0 {
+		return sql, params
+	}
+	filtered := make([]interface{}, len(params))
+	copy(filtered, params)
+	for _, i := range indexes {
+		if i >= 0 && i < len(filtered) {
+			filtered[i] = "***"
+		}
+	}
+	return sql, filtered
+}
+
+// sample 相同指纹的语句在采样间隔内只记录一次，
+// 每个间隔清理一次超过间隔的指纹，避免参数拼在 SQL 里时指纹无限增长
+func (l *sqlLogger) sample(sql string) bool {
+	key := fingerprint(sql)
+	now := time.Now().UnixNano()
+	interval := int64(l.sampleInterval)
+
+	s := l.sampled
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now >= s.sweepAt {
+		for k, last := range s.last {
+			if now-last >= interval {
+				delete(s.last, k)
+			}
+		}
+		s.sweepAt = now + interval
+	}
+	if last, ok := s.last[key]; ok && now-last < interval {
+		return false
+	}
+	s.last[key] = now
+	return true
+}
+
+var (
+	gormSourceDir       = filepath.Join("gorm.io", "gorm")
+	zapgormSourceDir    = filepath.Join("moul.io", "zapgorm2")
+	dbresolverDir       = filepath.Join("gorm.io", "plugin")
+	_, loggerFile, _, _ = runtime.Caller(0)
+)
+
+// withCaller 找到第一个不在 gorm 和本文件中的调用位置，与 zapgorm2 的逻辑一致
+func (l *sqlLogger) withCaller() zapgorm2.Logger {
+	inner := l.Logger
+	for i := 2; i < 15; i++ {
+		_, file, _, ok := runtime.Caller(i)
+		switch {
+		case !ok:
+		case strings.HasSuffix(file, "_test.go"):
+		case file == loggerFile:
+		case strings.Contains(file, gormSourceDir):
+		case strings.Contains(file, zapgormSourceDir):
+		case strings.Contains(file, dbresolverDir):
+		default:
+			inner.ZapLogger = inner.ZapLogger.WithOptions(zap.AddCallerSkip(i))
+			return inner
+		}
+	}
+	return inner
+}
+
+var (
+	insertColumnsRe = regexp.MustCompile(`(?is)^\s*INSERT\s+INTO\s+[^(]+\(([^)]*)\)\s*VALUES`)
+	columnBeforeRe  = regexp.MustCompile("(?i)[`\"]?(\\w+)[`\"]?\\s*(?:=|<>|!=|<=|>=|<|>|\\bLIKE|\\bIN\\s*\\(\\s*(?:(?:\\?|\\$\\d+)\\s*,\\s*)*)\\s*$")
+)
+
+// sensitiveParams 找出 SQL 中属于敏感字段的占位符下标，支持 col = ? 形式和 INSERT 的 VALUES 列表
+func sensitiveParams(sql string, columns map[string]bool) []int {
+	var (
+		insertColumns []string
+		valuesStart   = -1
+	)
+	if m := insertColumnsRe.FindStringSubmatchIndex(sql); m != nil {
+		valuesStart = m[1]
+		for _, column := range strings.Split(sql[m[2]:m[3]], ",") {
+			insertColumns = append(insertColumns, strings.ToLower(strings.Trim(strings.TrimSpace(column), "`\"")))
+		}
+	}
+
+	var (
+		indexes  []int
+		ordinal  int  // ? 占位符的序号
+		inValues bool // 是否在 INSERT 的 VALUES 列表中
+		valueIdx int  // VALUES 列表中第几个占位符
+		depth    int
+		quote    byte
+	)
+	inValues = valuesStart >= 0
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		if quote != 0 {
+			if c == quote {
+				quote = 0
+			}
+			continue
+		}
+
+		index, pos := -1, i
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == '?':
+			index = ordinal
+			ordinal++
+		case c == '$' && i+1 < len(sql) && sql[i+1] >= '0' && sql[i+1] <= '9':
+			j := i + 1
+			for j < len(sql) && sql[j] >= '0' && sql[j] <= '9' {
+				j++
+			}
+			n, _ := strconv.Atoi(sql[i+1 : j])
+			index = n - 1
+			i = j - 1
+		case inValues && i >= valuesStart && depth == 0 && c != ',' && c != ' ' && c != '\n' && c != '\t':
+			// VALUES 列表结束，例如 ON DUPLICATE KEY UPDATE / RETURNING
+			inValues = false
+		}
+		if index < 0 {
+			continue
+		}
+
+		if inValues && i >= valuesStart && len(insertColumns) > 0 {
+			if columns[insertColumns[valueIdx%len(insertColumns)]] {
+				indexes = append(indexes, index)
+			}
+			valueIdx++
+			continue
+		}
+		if m := columnBeforeRe.FindStringSubmatch(sql[:pos]); m != nil && columns[strings.ToLower(m[1])] {
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes
+}
+
+var (
+	stringLiteralRe = regexp.MustCompile(`'(?:[^']|'')*'`)
+	numberLiteralRe = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+	valueListRe     = regexp.MustCompile(`\(\s*\?(?:\s*,\s*\?)*\s*\)`)
+)
+
+// fingerprint 去掉 SQL 中的字面量，只保留语句结构
+func fingerprint(sql string) string {
+	sql = stringLiteralRe.ReplaceAllString(sql, "?")
+	sql = numberLiteralRe.ReplaceAllString(sql, "?")
+	return valueListRe.ReplaceAllString(sql, "(?)")
+}
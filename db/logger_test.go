@@ -0,0 +1,108 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+func TestSensitiveParams(t *testing.T) {
+	columns := map[string]bool{"password": true, "token": true}
+	cases := []struct {
+		sql  string
+		want []int
+	}{
+		{"SELECT * FROM `user` WHERE `name` = ? AND `password` = ?", []int{1}},
+		{"UPDATE `user` SET `token`=?,`level`=? WHERE id = ?", []int{0}},
+		{"INSERT INTO `user` (`name`,`password`,`level`) VALUES (?,?,?),(?,?,?)", []int{1, 4}},
+		{"INSERT INTO `user` (`name`,`password`) VALUES (?,?) ON DUPLICATE KEY UPDATE `token`=?,`name`=?", []int{1, 2}},
+		{`SELECT * FROM "user" WHERE "token" IN ($1,$2) AND name = $3`, []int{0, 1}},
+		{"SELECT * FROM `user` WHERE name = '?' AND password = ?", []int{0}},
+	}
+	for _, c := range cases {
+		if got := sensitiveParams(c.sql, columns); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("sensitiveParams(%q) = %v, want %v", c.sql, got, c.want)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	a := fingerprint("SELECT * FROM `item` WHERE uid = 1001 AND name = 'sword' AND id IN (1,2,3)")
+	b := fingerprint("SELECT * FROM `item` WHERE uid = 2002 AND name = 'shield' AND id IN (4,5)")
+	if a != b {
+		t.Errorf("fingerprints differ: %q != %q", a, b)
+	}
+}
+
+func TestSample(t *testing.T) {
+	l := newSQLLogger(SourceConfig{Name: "default", SampleInterval: 20 * time.Millisecond}, gormlogger.Info)
+	if !l.sample("SELECT * FROM `item` WHERE id = 1") || l.sample("SELECT * FROM `item` WHERE id = 2") {
+		t.Fatal("same fingerprint should be logged once per interval")
+	}
+	for i := 0; i < 100; i++ {
+		l.sample(fmt.Sprintf("SELECT * FROM `item_%c%c` WHERE id = 1", 'a'+i/10, 'a'+i%10))
+	}
+	if n := len(l.sampled.last); n != 101 {
+		t.Fatalf("%d fingerprints, want 101", n)
+	}
+
+	// 超过间隔的指纹被清理，不会无限增长
+	time.Sleep(40 * time.Millisecond)
+	if !l.sample("SELECT * FROM `item` WHERE id = 3") {
+		t.Error("fingerprint should be logged again after the interval")
+	}
+	if n := len(l.sampled.last); n != 1 {
+		t.Errorf("%d fingerprints kept, want 1", n)
+	}
+}
+
+func TestSQLLoggerFields(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	t.Cleanup(zap.ReplaceGlobals(zap.New(core)))
+	useSqlite(t)
+	dbManager := newReloadTestManager(t, SourceConfig{
+		Name:          "default",
+		Type:          TypeMysql,
+		DSN:           filepath.Join(t.TempDir(), "default.db"),
+		LogLevel:      "info",
+		RedactColumns: []string{"password"},
+	})
+	db := dbManager.GetGorm("default")
+	if err := db.Exec("CREATE TABLE users (name TEXT, password TEXT)").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	spanCtx := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3},
+		SpanID:     trace.SpanID{4, 5, 6},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), spanCtx)
+	logs.TakeAll()
+	if err := db.WithContext(ctx).Exec("INSERT INTO users (name, password) VALUES (?, ?)", "alice", "secret").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	entries := logs.TakeAll()
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	sql, _ := fields["sql"].(string)
+	if !strings.Contains(sql, "alice") || !strings.Contains(sql, "***") || strings.Contains(sql, "secret") {
+		t.Errorf("sql = %s", sql)
+	}
+	if fields["source"] != "default" || fields["trace_id"] != spanCtx.TraceID().String() || fields["span_id"] != spanCtx.SpanID().String() {
+		t.Errorf("fields = %v", fields)
+	}
+}
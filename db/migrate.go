@@ -0,0 +1,515 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// Migration 一个版本的迁移，Up 升级，Down 回滚
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// LoadMigrations 从 dir 目录加载迁移文件，一般配合 embed.FS 使用
+//
+// 文件名格式为 {version}_{name}.up.sql 和 {version}_{name}.down.sql，例如 0001_create_player.up.sql，
+// down 文件可以没有。文件中的多条语句按分号拆分后执行，第一行为 -- migrate:single 时整个文件作为一条语句。
+// 返回的迁移按版本号升序排列
+func LoadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+
+		base := strings.TrimSuffix(entry.Name(), ".sql")
+		direction := path.Ext(base)
+		if direction != ".up" && direction != ".down" {
+			return nil, fmt.Errorf("migration %s: missing .up or .down suffix", entry.Name())
+		}
+		base = strings.TrimSuffix(base, direction)
+
+		versionStr, name, _ := strings.Cut(base, "_")
+		version, err := strconv.ParseInt(versionStr, 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: invalid version", entry.Name())
+		}
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		} else if m.Name != name {
+			return nil, fmt.Errorf("migration version %d: conflicting names %s and %s", version, m.Name, name)
+		}
+		if direction == ".up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration version %d: missing up file", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// MigrationLocker 保证同一时间只有一个副本在执行迁移，Lock 和 Unlock 在同一个连接上调用
+type MigrationLocker interface {
+	Lock(ctx context.Context, conn *gorm.DB) error
+	Unlock(ctx context.Context, conn *gorm.DB) error
+}
+
+// MigrateOptions 迁移选项，nil 时使用默认值
+type MigrateOptions struct {
+	Table       string          // 记录已执行版本的表，默认 schema_migrations
+	DryRun      bool            // 只打印要执行的 SQL，不实际执行
+	LockTimeout time.Duration   // 等待迁移锁的时间，默认 1 分钟
+	Locker      MigrationLocker // 默认 mysql 用 GET_LOCK，pgsql 用 advisory lock，其它数据库不加锁
+}
+
+const (
+	defaultMigrationTable       = "schema_migrations"
+	defaultMigrationLockTimeout = time.Minute
+)
+
+// Migrator 在一个 gorm 数据源上执行版本迁移
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+	opts       MigrateOptions
+}
+
+func NewMigrator(db *gorm.DB, migrations []Migration, opts *MigrateOptions) *Migrator {
+	m := &Migrator{db: db, migrations: migrations}
+	if opts != nil {
+		m.opts = *opts
+	}
+	if m.opts.Table == "" {
+		m.opts.Table = defaultMigrationTable
+	}
+	if m.opts.LockTimeout <= 0 {
+		m.opts.LockTimeout = defaultMigrationLockTimeout
+	}
+	if m.opts.Locker == nil {
+		m.opts.Locker = newMigrationLocker(db.Dialector.Name(), m.opts.Table, m.opts.LockTimeout)
+	}
+	return m
+}
+
+// Migrate 加载 dir 目录中的迁移文件，并在 name 数据源上执行所有未执行的版本
+func (dbManager *DBManager) Migrate(ctx context.Context, name string, fsys fs.FS, dir string, opts *MigrateOptions) ([]Migration, error) {
+	db := dbManager.GetGorm(name)
+	if db == nil {
+		return nil, fmt.Errorf("source %s not found", name)
+	}
+	migrations, err := LoadMigrations(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	return NewMigrator(db, migrations, opts).Up(ctx)
+}
+
+// Up 按版本顺序执行所有未执行的迁移，返回本次执行（DryRun 时为将要执行）的迁移
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+	err := m.withLock(ctx, func(conn *gorm.DB) error {
+		applied, err := m.appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if applied[migration.Version] {
+				continue
+			}
+			if err := m.apply(conn, migration, migration.Up, true); err != nil {
+				return err
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down 回滚最近执行的 steps 个版本，返回本次回滚（DryRun 时为将要回滚）的迁移
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+	err := m.withLock(ctx, func(conn *gorm.DB) error {
+		applied, err := m.appliedVersions(conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			migration := m.migrations[i]
+			if !applied[migration.Version] {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s: no down file", migration.Version, migration.Name)
+			}
+			if err := m.apply(conn, migration, migration.Down, false); err != nil {
+				return err
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Applied 已经执行的版本号，升序，从主库读取
+func (m *Migrator) Applied(ctx context.Context) ([]int64, error) {
+	applied, err := m.appliedVersions(m.db.WithContext(ctx).Clauses(dbresolver.Write))
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]int64, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions, nil
+}
+
+// withLock 在主库的同一个连接上加锁、建表并执行 fc
+func (m *Migrator) withLock(ctx context.Context, fc func(conn *gorm.DB) error) error {
+	// db.DB() 总是主库的连接池，从库由 dbresolver 在每条语句执行前切换
+	sqlDB, err := m.db.DB()
+	if err != nil {
+		return err
+	}
+	sqlConn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer sqlConn.Close()
+
+	conn := m.db.WithContext(ctx)
+	conn.Statement.ConnPool = pinnedConn{sqlConn}
+
+	if err := m.opts.Locker.Lock(ctx, conn); err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	defer func() {
+		// ctx 取消后 conn 上的语句不会发到数据库，解锁使用不会取消的 ctx
+		unlockCtx := context.WithoutCancel(ctx)
+		if err := m.opts.Locker.Unlock(unlockCtx, conn.WithContext(unlockCtx)); err != nil {
+			zap.L().Warn("release migration lock failed", zap.Error(err))
+			// sql.Conn.Close 只是放回连接池，锁还在会话上，丢弃连接让数据库断开会话释放锁
+			sqlConn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+	}()
+
+	if !m.opts.DryRun {
+		sql := "CREATE TABLE IF NOT EXISTS " + m.opts.Table +
+			" (version BIGINT NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at TIMESTAMP NOT NULL)"
+		if err := conn.Exec(sql).Error; err != nil {
+			return fmt.Errorf("create migration table: %w", err)
+		}
+	}
+	return fc(conn)
+}
+
+// pinnedConn 固定在主库上的连接。dbresolver 会把每条不在事务中的语句切换到主库或从库的连接池，
+// 实现 gorm.TxCommitter 后会被当作事务跳过切换，加锁、读版本和执行迁移才能在同一个连接上。
+// 因此不能在它上面调用 gorm 的 Transaction（会被当作嵌套事务），开启事务使用 Begin
+type pinnedConn struct {
+	*sql.Conn
+}
+
+func (pinnedConn) Commit() error   { return errors.New("migration connection is not a transaction") }
+func (pinnedConn) Rollback() error { return errors.New("migration connection is not a transaction") }
+
+func (m *Migrator) appliedVersions(conn *gorm.DB) (map[int64]bool, error) {
+	applied := make(map[int64]bool)
+	if !conn.Migrator().HasTable(m.opts.Table) {
+		return applied, nil
+	}
+
+	var versions []int64
+	if err := conn.Raw("SELECT version FROM " + m.opts.Table).Scan(&versions).Error; err != nil {
+		return nil, err
+	}
+	for _, version := range versions {
+		applied[version] = true
+	}
+	return applied, nil
+}
+
+// apply 在事务中执行迁移 SQL 并更新版本记录，mysql 的 DDL 会隐式提交，失败时需要人工处理
+func (m *Migrator) apply(conn *gorm.DB, migration Migration, script string, up bool) error {
+	statements := splitStatements(script, m.db.Dialector.Name())
+	direction := "up"
+	if !up {
+		direction = "down"
+	}
+
+	if m.opts.DryRun {
+		for _, stmt := range statements {
+			zap.L().Info("migration dry run", zap.Int64("version", migration.Version),
+				zap.String("name", migration.Name), zap.String("direction", direction), zap.String("sql", stmt))
+		}
+		return nil
+	}
+
+	err := inTransaction(conn, func(tx *gorm.DB) error {
+		for _, stmt := range statements {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		if up {
+			return tx.Exec("INSERT INTO "+m.opts.Table+" (version, name, applied_at) VALUES (?, ?, ?)",
+				migration.Version, migration.Name, time.Now()).Error
+		}
+		return tx.Exec("DELETE FROM "+m.opts.Table+" WHERE version = ?", migration.Version).Error
+	})
+	if err != nil {
+		return fmt.Errorf("migration %d_%s %s: %w", migration.Version, migration.Name, direction, err)
+	}
+	zap.L().Info("migration applied", zap.Int64("version", migration.Version),
+		zap.String("name", migration.Name), zap.String("direction", direction))
+	return nil
+}
+
+// inTransaction 在 pinnedConn 上开启事务执行 fc，fc 出错或 panic 时回滚
+func inTransaction(conn *gorm.DB, fc func(tx *gorm.DB) error) (err error) {
+	tx := conn.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tx.Rollback()
+		}
+	}()
+
+	if err = fc(tx); err != nil {
+		return err
+	}
+	committed = true
+	return tx.Commit().Error
+}
+
+// singleStatementMarker 迁移文件第一行是这个注释时整个文件作为一条语句执行，
+// 用于 mysql 中带 BEGIN ... END 的存储过程和触发器
+const singleStatementMarker = "-- migrate:single"
+
+// splitStatements 按分号拆分多条 SQL，忽略引号、注释以及 pgsql $$ 函数体中的分号。
+// mysql 的字符串中反斜杠是转义符，pgsql 中不是
+func splitStatements(script string, dialect string) []string {
+	script = strings.TrimSpace(script)
+	if first, _, _ := strings.Cut(script, "\n"); strings.TrimSpace(first) == singleStatementMarker {
+		return []string{script}
+	}
+
+	var (
+		statements   []string
+		current      strings.Builder
+		quote        rune
+		dollarTag    string
+		lineComment  bool
+		blockComment bool
+		backslash    = dialect == "mysql" // 反斜杠转义
+	)
+	flush := func() {
+		if stmt := strings.TrimSpace(current.String()); stmt != "" {
+			statements = append(statements, stmt)
+		}
+		current.Reset()
+	}
+
+	runes := []rune(script)
+	next := func(i int) rune {
+		if i+1 < len(runes) {
+			return runes[i+1]
+		}
+		return 0
+	}
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case lineComment:
+			if r == '\n' {
+				lineComment = false
+				current.WriteRune(r)
+			}
+			continue
+		case blockComment:
+			// 块注释原样保留，mysql 的 /*! ... */ 是会执行的
+			if r == '*' && next(i) == '/' {
+				blockComment = false
+				current.WriteString("*/")
+				i++
+				continue
+			}
+		case quote != 0:
+			if backslash && r == '\\' && i+1 < len(runes) {
+				current.WriteRune(r)
+				current.WriteRune(runes[i+1])
+				i++
+				continue
+			}
+			if r == quote {
+				quote = 0
+			}
+		case dollarTag != "":
+			if strings.HasPrefix(string(runes[i:min(i+len(dollarTag), len(runes))]), dollarTag) {
+				current.WriteString(dollarTag)
+				i += len(dollarTag) - 1
+				dollarTag = ""
+				continue
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '-' && next(i) == '-':
+			lineComment = true
+			continue
+		case r == '/' && next(i) == '*':
+			blockComment = true
+			current.WriteString("/*")
+			i++
+			continue
+		case r == '$' && !backslash && (i == 0 || !isIdentRune(runes[i-1])):
+			if tag := dollarQuoteTag(runes[i:]); tag != "" {
+				dollarTag = tag
+				current.WriteString(tag)
+				i += len(tag) - 1
+				continue
+			}
+		case r == ';':
+			flush()
+			continue
+		}
+		current.WriteRune(r)
+	}
+	flush()
+	return statements
+}
+
+// dollarQuoteTag 返回 runes 开头的 pgsql 美元引号标记，如 $$ 或 $body$，不是标记时返回空字符串
+func dollarQuoteTag(runes []rune) string {
+	for j := 1; j < len(runes); j++ {
+		switch {
+		case runes[j] == '$':
+			return string(runes[:j+1])
+		case !isIdentRune(runes[j]) || (j == 1 && runes[j] >= '0' && runes[j] <= '9'):
+			// $1 是参数占位符
+			return ""
+		}
+	}
+	return ""
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
+
+func newMigrationLocker(dialect string, table string, timeout time.Duration) MigrationLocker {
+	switch dialect {
+	case "mysql":
+		return &mysqlMigrationLocker{name: "migrate:" + table, timeout: timeout}
+	case "postgres":
+		h := fnv.New64a()
+		h.Write([]byte("migrate:" + table))
+		return &pgsqlMigrationLocker{key: int64(h.Sum64()), timeout: timeout}
+	}
+	return noopMigrationLocker{}
+}
+
+// mysqlMigrationLocker 使用 GET_LOCK 命名锁，锁属于会话，连接放回连接池后仍然持有，
+// 解锁失败时 withLock 丢弃连接由数据库释放
+type mysqlMigrationLocker struct {
+	name    string
+	timeout time.Duration
+}
+
+func (l *mysqlMigrationLocker) Lock(ctx context.Context, conn *gorm.DB) error {
+	var got *int
+	seconds := int(l.timeout.Seconds())
+	if err := conn.Raw("SELECT GET_LOCK(?, ?)", l.name, seconds).Scan(&got).Error; err != nil {
+		return err
+	}
+	if got == nil || *got != 1 {
+		return errors.New("timeout waiting for migration lock")
+	}
+	return nil
+}
+
+func (l *mysqlMigrationLocker) Unlock(ctx context.Context, conn *gorm.DB) error {
+	return conn.Exec("SELECT RELEASE_LOCK(?)", l.name).Error
+}
+
+// pgsqlMigrationLocker 使用会话级 advisory lock，和 mysqlMigrationLocker 一样解锁失败时丢弃连接
+type pgsqlMigrationLocker struct {
+	key     int64
+	timeout time.Duration
+}
+
+func (l *pgsqlMigrationLocker) Lock(ctx context.Context, conn *gorm.DB) error {
+	deadline := time.Now().Add(l.timeout)
+	for {
+		var got bool
+		if err := conn.Raw("SELECT pg_try_advisory_lock(?)", l.key).Scan(&got).Error; err != nil {
+			return err
+		}
+		if got {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("timeout waiting for migration lock")
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (l *pgsqlMigrationLocker) Unlock(ctx context.Context, conn *gorm.DB) error {
+	return conn.Exec("SELECT pg_advisory_unlock(?)", l.key).Error
+}
+
+type noopMigrationLocker struct{}
+
+func (noopMigrationLocker) Lock(ctx context.Context, conn *gorm.DB) error   { return nil }
+func (noopMigrationLocker) Unlock(ctx context.Context, conn *gorm.DB) error { return nil }
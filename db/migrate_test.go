@@ -0,0 +1,273 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+var testMigrations = fstest.MapFS{
+	"migrations/0001_create_player.up.sql":   {Data: []byte("CREATE TABLE player (id INTEGER PRIMARY KEY, name TEXT);")},
+	"migrations/0001_create_player.down.sql": {Data: []byte("DROP TABLE player;")},
+	"migrations/0002_add_level.up.sql": {Data: []byte(`
+-- 等级; 默认 1
+ALTER TABLE player ADD COLUMN level INTEGER DEFAULT 1;
+INSERT INTO player (id, name) VALUES (1, 'a;b');`)},
+	"migrations/0002_add_level.down.sql": {Data: []byte("ALTER TABLE player DROP COLUMN level;")},
+	"migrations/README.md":               {Data: []byte("ignored")},
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := LoadMigrations(testMigrations, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || migrations[0].Version != 1 || migrations[1].Name != "add_level" {
+		t.Fatalf("unexpected migrations: %+v", migrations)
+	}
+
+	bad := fstest.MapFS{"m/abc_x.up.sql": {Data: []byte("SELECT 1")}}
+	if _, err := LoadMigrations(bad, "m"); err == nil {
+		t.Error("expected invalid version error")
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect string
+		script  string
+		want    []string
+	}{
+		{
+			name:    "quote and line comment",
+			dialect: "sqlite",
+			script:  "-- 注释; 不拆分\nCREATE TABLE a (x TEXT);\nINSERT INTO a VALUES ('1;2');\n",
+			want:    []string{"CREATE TABLE a (x TEXT)", "INSERT INTO a VALUES ('1;2')"},
+		},
+		{
+			name:    "pgsql dollar quote",
+			dialect: "postgres",
+			script: `CREATE FUNCTION touch() RETURNS trigger AS $$
+BEGIN
+	NEW.updated_at = now();
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+SELECT $1;`,
+			want: []string{"CREATE FUNCTION touch() RETURNS trigger AS $$\nBEGIN\n\tNEW.updated_at = now();\n\tRETURN NEW;\nEND;\n$$ LANGUAGE plpgsql", "SELECT $1"},
+		},
+		{
+			name:    "pgsql tagged dollar quote",
+			dialect: "postgres",
+			script:  "DO $body$ BEGIN PERFORM $$a;b$$; END $body$;\nSELECT 'C:\\';SELECT 2",
+			want:    []string{"DO $body$ BEGIN PERFORM $$a;b$$; END $body$", "SELECT 'C:\\'", "SELECT 2"},
+		},
+		{
+			name:    "block comment",
+			dialect: "mysql",
+			script:  "/* 建表; 注释 */ CREATE TABLE a (x INT) /*!50100 ENGINE=InnoDB */;DROP TABLE b",
+			want:    []string{"/* 建表; 注释 */ CREATE TABLE a (x INT) /*!50100 ENGINE=InnoDB */", "DROP TABLE b"},
+		},
+		{
+			name:    "mysql backslash escape",
+			dialect: "mysql",
+			script:  `INSERT INTO a VALUES ('it\'s; fine', "say \"hi;\"");SELECT 1`,
+			want:    []string{`INSERT INTO a VALUES ('it\'s; fine', "say \"hi;\"")`, "SELECT 1"},
+		},
+		{
+			name:    "single statement",
+			dialect: "mysql",
+			script:  "-- migrate:single\nCREATE TRIGGER t BEFORE INSERT ON a FOR EACH ROW BEGIN SET NEW.x = 1; END;\n",
+			want:    []string{"-- migrate:single\nCREATE TRIGGER t BEFORE INSERT ON a FOR EACH ROW BEGIN SET NEW.x = 1; END;"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitStatements(tt.script, tt.dialect); !slices.Equal(got, tt.want) {
+				t.Errorf("splitStatements() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// fileLocker 记录加锁和解锁时连接所在的数据库文件
+type fileLocker struct {
+	files []string
+}
+
+func (l *fileLocker) Lock(ctx context.Context, conn *gorm.DB) error {
+	return l.record(conn)
+}
+
+func (l *fileLocker) Unlock(ctx context.Context, conn *gorm.DB) error {
+	return l.record(conn)
+}
+
+func (l *fileLocker) record(conn *gorm.DB) error {
+	var file string
+	err := conn.Raw("SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&file).Error
+	l.files = append(l.files, file)
+	return err
+}
+
+func TestMigratorWithReplica(t *testing.T) {
+	dir := t.TempDir()
+	primary := filepath.Join(dir, "primary.db")
+	db, err := gorm.Open(sqlite.Open(primary), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Use(dbresolver.Register(dbresolver.Config{
+		Replicas: []gorm.Dialector{sqlite.Open(filepath.Join(dir, "replica.db"))},
+	})); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { closeSource(db, nil) })
+
+	migrations, err := LoadMigrations(testMigrations, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	locker := &fileLocker{}
+	m := NewMigrator(db, migrations, &MigrateOptions{Locker: locker})
+
+	// 读版本走从库时第二次会重复执行迁移
+	for i := 0; i < 2; i++ {
+		done, err := m.Up(ctx)
+		if err != nil {
+			t.Fatalf("up %d: %v", i, err)
+		}
+		if want := 2 - 2*i; len(done) != want {
+			t.Fatalf("up %d applied %d migrations, want %d", i, len(done), want)
+		}
+	}
+	for _, file := range locker.files {
+		if file != primary {
+			t.Errorf("lock ran on %s, want primary", file)
+		}
+	}
+	if versions, err := m.Applied(ctx); err != nil || len(versions) != 2 {
+		t.Errorf("applied: %v %v", versions, err)
+	}
+}
+
+func TestMigratorUpDown(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1) // 内存数据库每个连接都是独立的库
+
+	migrations, err := LoadMigrations(testMigrations, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// dry run 不执行也不建表
+	planned, err := NewMigrator(db, migrations, &MigrateOptions{DryRun: true}).Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(planned) != 2 || db.Migrator().HasTable("player") || db.Migrator().HasTable(defaultMigrationTable) {
+		t.Fatal("dry run should not change the database")
+	}
+
+	m := NewMigrator(db, migrations, nil)
+	done, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 2 {
+		t.Fatalf("applied %d migrations, want 2", len(done))
+	}
+
+	var name string
+	db.Raw("SELECT name FROM player WHERE id = 1").Scan(&name)
+	if name != "a;b" {
+		t.Errorf("name = %q, want a;b", name)
+	}
+
+	// 再次执行没有新的迁移
+	if done, err = m.Up(ctx); err != nil || len(done) != 0 {
+		t.Fatalf("second up: %v %v", done, err)
+	}
+
+	if done, err = m.Down(ctx, 1); err != nil || len(done) != 1 || done[0].Version != 2 {
+		t.Fatalf("down: %v %v", done, err)
+	}
+	versions, err := m.Applied(ctx)
+	if err != nil || len(versions) != 1 || versions[0] != 1 {
+		t.Fatalf("applied: %v %v", versions, err)
+	}
+}
+
+// sessionLocker 在连接上建临时表模拟会话级的锁，记录解锁时收到的 ctx
+type sessionLocker struct {
+	cancel    context.CancelFunc
+	unlockErr error
+	ctxErrs   []error // Unlock 的 ctx 和 conn 的 ctx
+}
+
+func (l *sessionLocker) Lock(ctx context.Context, conn *gorm.DB) error {
+	if err := conn.Exec("CREATE TEMP TABLE migration_lock (id INTEGER)").Error; err != nil {
+		return err
+	}
+	l.cancel()
+	return nil
+}
+
+func (l *sessionLocker) Unlock(ctx context.Context, conn *gorm.DB) error {
+	l.ctxErrs = []error{ctx.Err(), conn.Statement.Context.Err()}
+	if l.unlockErr != nil {
+		return l.unlockErr
+	}
+	return conn.Exec("DROP TABLE temp.migration_lock").Error
+}
+
+func TestMigratorUnlockAfterCancel(t *testing.T) {
+	migrations, err := LoadMigrations(testMigrations, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasLock := func(db *gorm.DB) bool {
+		var n int
+		db.Raw("SELECT COUNT(*) FROM sqlite_temp_master WHERE name = 'migration_lock'").Scan(&n)
+		return n > 0
+	}
+
+	for _, unlockErr := range []error{nil, errors.New("unlock failed")} {
+		db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "game.db")), &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sqlDB, _ := db.DB()
+		sqlDB.SetMaxOpenConns(1)
+
+		// 加锁后 ctx 被取消，迁移失败，解锁仍然要在数据库上执行。
+		// sqlite 驱动可能直接返回 ctx 的错误，也可能中断语句返回 interrupted，这里只要求失败
+		ctx, cancel := context.WithCancel(context.Background())
+		locker := &sessionLocker{cancel: cancel, unlockErr: unlockErr}
+		if _, err := NewMigrator(db, migrations, &MigrateOptions{Locker: locker}).Up(ctx); err == nil {
+			t.Fatal("up should fail after ctx is cancelled")
+		}
+		if locker.ctxErrs[0] != nil || locker.ctxErrs[1] != nil {
+			t.Errorf("unlock ctx errors = %v", locker.ctxErrs)
+		}
+		// 解锁失败时连接被丢弃，锁不会回到连接池
+		if hasLock(db) {
+			t.Errorf("unlock err %v: lock still held by pooled connection", unlockErr)
+		}
+		sqlDB.Close()
+	}
+}
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// Option 数据源的可选配置
+type Option func(*SourceConfig)
+
+// WithMaxOpenConns 最大打开连接数，redis 对应 PoolSize
+func WithMaxOpenConns(n int) Option {
+	return func(cfg *SourceConfig) { cfg.MaxOpenConns = n }
+}
+
+// WithMaxIdleConns 最大空闲连接数
+func WithMaxIdleConns(n int) Option {
+	return func(cfg *SourceConfig) { cfg.MaxIdleConns = n }
+}
+
+// WithConnMaxLifetime 连接最长存活时间
+func WithConnMaxLifetime(d time.Duration) Option {
+	return func(cfg *SourceConfig) { cfg.ConnMaxLifetime = d }
+}
+
+// WithConnMaxIdleTime 连接最长空闲时间
+func WithConnMaxIdleTime(d time.Duration) Option {
+	return func(cfg *SourceConfig) { cfg.ConnMaxIdleTime = d }
+}
+
+// WithConnectTimeout 建立连接的超时时间，同时作为启动 ping 的超时
+func WithConnectTimeout(d time.Duration) Option {
+	return func(cfg *SourceConfig) { cfg.ConnectTimeout = d }
+}
+
+// WithReadTimeout 读超时，pgsql 不支持
+func WithReadTimeout(d time.Duration) Option {
+	return func(cfg *SourceConfig) { cfg.ReadTimeout = d }
+}
+
+// WithWriteTimeout 写超时，pgsql 不支持
+func WithWriteTimeout(d time.Duration) Option {
+	return func(cfg *SourceConfig) { cfg.WriteTimeout = d }
+}
+
+// WithLogLevel gorm 日志级别：silent / error / warn / info
+func WithLogLevel(level string) Option {
+	return func(cfg *SourceConfig) { cfg.LogLevel = level }
+}
+
+// WithSlowThreshold 慢查询阈值
+func WithSlowThreshold(d time.Duration) Option {
+	return func(cfg *SourceConfig) { cfg.SlowThreshold = d }
+}
+
+// WithIgnoreRecordNotFound 不把 record not found 当作错误记录
+func WithIgnoreRecordNotFound() Option {
+	return func(cfg *SourceConfig) { cfg.IgnoreRecordNotFound = true }
+}
+
+// WithRedactColumns 日志中这些字段的参数显示为 ***
+func WithRedactColumns(columns ...string) Option {
+	return func(cfg *SourceConfig) { cfg.RedactColumns = append(cfg.RedactColumns, columns...) }
+}
+
+// WithSampleInterval 相同结构的语句在间隔内只记录一次
+func WithSampleInterval(d time.Duration) Option {
+	return func(cfg *SourceConfig) { cfg.SampleInterval = d }
+}
+
+// WithTelemetry 开启 opentelemetry 的 span 和耗时指标
+func WithTelemetry() Option {
+	return func(cfg *SourceConfig) { cfg.Telemetry = true }
+}
+
+// WithPingRetry 启动时 ping 失败后的重试次数和首次退避时间，每次重试退避时间翻倍
+func WithPingRetry(retries int, backoff time.Duration) Option {
+	return func(cfg *SourceConfig) {
+		cfg.PingRetries = retries
+		cfg.PingBackoff = backoff
+	}
+}
+
+const (
+	defaultPingTimeout = 3 * time.Second
+	defaultPingBackoff = time.Second
+	maxPingBackoff     = 30 * time.Second
+)
+
+// pingWithRetry 按配置的重试次数执行 ping，全部失败时返回最后一次的错误
+func pingWithRetry(cfg SourceConfig, ping func(ctx context.Context) error) error {
+	timeout := cfg.ConnectTimeout
+	if timeout <= 0 {
+		timeout = defaultPingTimeout
+	}
+	backoff := cfg.PingBackoff
+	if backoff <= 0 {
+		backoff = defaultPingBackoff
+	}
+
+	var err error
+	for i := 0; i <= cfg.PingRetries; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff = min(backoff*2, maxPingBackoff)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err = ping(ctx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
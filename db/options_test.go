@@ -0,0 +1,118 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPingWithRetry(t *testing.T) {
+	errDown := errors.New("connection refused")
+	cfg := SourceConfig{PingRetries: 3, PingBackoff: time.Millisecond, ConnectTimeout: 20 * time.Millisecond}
+
+	// 失败 N 次后成功
+	attempts := 0
+	err := pingWithRetry(cfg, func(ctx context.Context) error {
+		attempts++
+		if attempts <= 2 {
+			return errDown
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Errorf("err = %v, attempts = %d", err, attempts)
+	}
+
+	// 每次 ping 都超时，重试用完后返回最后一次的超时错误
+	attempts = 0
+	begin := time.Now()
+	err = pingWithRetry(cfg, func(ctx context.Context) error {
+		attempts++
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) || attempts != 4 {
+		t.Errorf("err = %v, attempts = %d", err, attempts)
+	}
+	if elapsed := time.Since(begin); elapsed < 4*cfg.ConnectTimeout || elapsed > time.Second {
+		t.Errorf("elapsed = %v", elapsed)
+	}
+
+	// 不重试时只 ping 一次
+	attempts = 0
+	err = pingWithRetry(SourceConfig{}, func(ctx context.Context) error {
+		attempts++
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("ping ctx should have a deadline")
+		}
+		return errDown
+	})
+	if err != errDown || attempts != 1 {
+		t.Errorf("err = %v, attempts = %d", err, attempts)
+	}
+}
+
+func TestInitWithOptions(t *testing.T) {
+	useSqlite(t)
+	dsn := filepath.Join(t.TempDir(), "default.db")
+	dbManager := NewDBManager()
+	t.Cleanup(func() { dbManager.Close() })
+
+	err := dbManager.InitWithOptions("default", TypeMysql, dsn,
+		WithMaxOpenConns(8),
+		WithMaxIdleConns(4),
+		WithConnMaxLifetime(time.Hour),
+		WithConnMaxIdleTime(time.Minute),
+		WithConnectTimeout(time.Second),
+		WithReadTimeout(2*time.Second),
+		WithWriteTimeout(3*time.Second),
+		WithLogLevel("info"),
+		WithSlowThreshold(time.Millisecond),
+		WithIgnoreRecordNotFound(),
+		WithRedactColumns("password"),
+		WithRedactColumns("token"),
+		WithSampleInterval(time.Second),
+		WithPingRetry(2, time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := SourceConfig{
+		Name:                 "default",
+		Type:                 TypeMysql,
+		DSN:                  dsn,
+		MaxOpenConns:         8,
+		MaxIdleConns:         4,
+		ConnMaxLifetime:      time.Hour,
+		ConnMaxIdleTime:      time.Minute,
+		ConnectTimeout:       time.Second,
+		ReadTimeout:          2 * time.Second,
+		WriteTimeout:         3 * time.Second,
+		PingRetries:          2,
+		PingBackoff:          time.Millisecond,
+		LogLevel:             "info",
+		SlowThreshold:        time.Millisecond,
+		IgnoreRecordNotFound: true,
+		RedactColumns:        []string{"password", "token"},
+		SampleInterval:       time.Second,
+	}
+	dbManager.mu.RLock()
+	got := dbManager.configs[sourceKey(TypeMysql, "default")]
+	dbManager.mu.RUnlock()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("config = %+v\nwant %+v", got, want)
+	}
+
+	sqlDB, _ := dbManager.GetGorm("default").DB()
+	if n := sqlDB.Stats().MaxOpenConnections; n != 8 {
+		t.Errorf("max open conns = %d, want 8", n)
+	}
+
+	if err := dbManager.InitWithOptions("bad", TypeMysql, dsn, WithLogLevel("verbose")); err == nil {
+		t.Error("invalid log level should fail")
+	}
+}
@@ -0,0 +1,95 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zuodazuoqianggame/common/utils"
+)
+
+// openRedis 按类型创建 redis 客户端，DSN 格式见 utils.ParseRedisURL 等函数
+func openRedis(cfg SourceConfig) (redis.UniversalClient, error) {
+	var client redis.UniversalClient
+	switch cfg.Type {
+	case TypeRedis:
+		opt, err := utils.ParseRedisURL(cfg.DSN)
+		if err != nil {
+			return nil, err
+		}
+		applyRedisPool(cfg, &opt.PoolSize, &opt.MaxIdleConns, &opt.ConnMaxLifetime, &opt.ConnMaxIdleTime,
+			&opt.DialTimeout, &opt.ReadTimeout, &opt.WriteTimeout)
+		client = redis.NewClient(opt)
+	case TypeRedisCluster:
+		opt, err := utils.ParseRedisClusterURL(cfg.DSN)
+		if err != nil {
+			return nil, err
+		}
+		applyRedisPool(cfg, &opt.PoolSize, &opt.MaxIdleConns, &opt.ConnMaxLifetime, &opt.ConnMaxIdleTime,
+			&opt.DialTimeout, &opt.ReadTimeout, &opt.WriteTimeout)
+		client = redis.NewClusterClient(opt)
+	case TypeRedisSentinel:
+		opt, err := utils.ParseRedisSentinelURL(cfg.DSN)
+		if err != nil {
+			return nil, err
+		}
+		applyRedisPool(cfg, &opt.PoolSize, &opt.MaxIdleConns, &opt.ConnMaxLifetime, &opt.ConnMaxIdleTime,
+			&opt.DialTimeout, &opt.ReadTimeout, &opt.WriteTimeout)
+		client = redis.NewFailoverClient(opt)
+	case TypeRedisRing:
+		opt, err := utils.ParseRedisRingURL(cfg.DSN)
+		if err != nil {
+			return nil, err
+		}
+		applyRedisPool(cfg, &opt.PoolSize, &opt.MaxIdleConns, &opt.ConnMaxLifetime, &opt.ConnMaxIdleTime,
+			&opt.DialTimeout, &opt.ReadTimeout, &opt.WriteTimeout)
+		client = redis.NewRing(opt)
+	default:
+		return nil, fmt.Errorf("unsupported redis type: %s", cfg.Type)
+	}
+
+	err := pingWithRetry(cfg, func(ctx context.Context) error {
+		return client.Ping(ctx).Err()
+	})
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect redis: %w", err)
+	}
+
+	if cfg.Telemetry {
+		hook, err := newRedisTelemetry(cfg.Name)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+		client.AddHook(hook)
+	}
+	return client, nil
+}
+
+// applyRedisPool 用数据源配置覆盖 DSN 中的连接池和超时参数，0 表示不覆盖
+func applyRedisPool(cfg SourceConfig, poolSize, maxIdleConns *int, connMaxLifetime, connMaxIdleTime,
+	dialTimeout, readTimeout, writeTimeout *time.Duration) {
+	if cfg.MaxOpenConns > 0 {
+		*poolSize = cfg.MaxOpenConns
+	}
+	if cfg.MaxIdleConns > 0 {
+		*maxIdleConns = cfg.MaxIdleConns
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		*connMaxLifetime = cfg.ConnMaxLifetime
+	}
+	if cfg.ConnMaxIdleTime > 0 {
+		*connMaxIdleTime = cfg.ConnMaxIdleTime
+	}
+	if cfg.ConnectTimeout > 0 {
+		*dialTimeout = cfg.ConnectTimeout
+	}
+	if cfg.ReadTimeout > 0 {
+		*readTimeout = cfg.ReadTimeout
+	}
+	if cfg.WriteTimeout > 0 {
+		*writeTimeout = cfg.WriteTimeout
+	}
+}
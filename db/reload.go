@@ -0,0 +1,123 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Reload 用新的 DSN 重建数据源，其它配置保持不变
+// redis://、rediss://、unix:// 开头的 DSN 对应 redis 数据源，其它对应 mysql/pgsql 数据源
+// 新连接 ping 通过后才会替换，失败时旧连接继续使用
+func (dbManager *DBManager) Reload(name string, newDSN string) error {
+	dbType := TypeMysql
+	if isRedisDSN(newDSN) {
+		dbType = TypeRedis
+	}
+
+	dbManager.mu.RLock()
+	cfg, ok := dbManager.configs[sourceKey(dbType, name)]
+	dbManager.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("source %s not found", name)
+	}
+
+	cfg.DSN = newDSN
+	return dbManager.initSource(cfg)
+}
+
+// ReloadConfig 对比新旧配置，只重建有变化的数据源，新增的初始化，删除的关闭
+func (dbManager *DBManager) ReloadConfig(cfg *Config) error {
+	sources, errs := validSources(cfg)
+	if cfg == nil {
+		return errors.Join(errs...)
+	}
+
+	dbManager.mu.RLock()
+	oldConfigs := make(map[string]SourceConfig, len(dbManager.configs))
+	for key, old := range dbManager.configs {
+		oldConfigs[key] = old
+	}
+	dbManager.mu.RUnlock()
+
+	wanted := make(map[string]bool)
+	for _, source := range sources {
+		key := sourceKey(source.Type, source.Name)
+		wanted[key] = true
+		if old, ok := oldConfigs[key]; ok && reflect.DeepEqual(old, source) {
+			continue
+		}
+		if err := dbManager.initSource(source); err != nil {
+			errs = append(errs, fmt.Errorf("source %s: %w", source.Name, err))
+		}
+	}
+
+	// 配置中已经删除的数据源
+	for key, old := range oldConfigs {
+		if !wanted[key] {
+			if err := dbManager.removeSource(old); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// removeSource 移除数据源，连接延迟关闭。
+// 还被分片组使用的数据源不能移除，需要先用 RegisterShardGroup 重新注册不包含它的分片组
+func (dbManager *DBManager) removeSource(cfg SourceConfig) error {
+	dbManager.mu.Lock()
+	if !isRedisType(cfg.Type) {
+		for group, strategy := range dbManager.shardGroups {
+			if slices.Contains(strategy.Shards(), cfg.Name) {
+				dbManager.mu.Unlock()
+				return fmt.Errorf("source %s: still used by shard group %s", cfg.Name, group)
+			}
+		}
+	}
+	var (
+		db     *gorm.DB
+		client redis.UniversalClient
+	)
+	if isRedisType(cfg.Type) {
+		client = dbManager.redisMap[cfg.Name]
+		delete(dbManager.redisMap, cfg.Name)
+	} else {
+		db = dbManager.dbMap[cfg.Name]
+		delete(dbManager.dbMap, cfg.Name)
+	}
+	delete(dbManager.configs, sourceKey(cfg.Type, cfg.Name))
+	drainTimeout := dbManager.drainTimeout
+	dbManager.mu.Unlock()
+
+	drain(cfg.Name, db, client, drainTimeout)
+	return nil
+}
+
+// drain 延迟关闭被替换掉的连接，给还拿着旧连接的请求留出执行时间
+func drain(name string, db *gorm.DB, client redis.UniversalClient, timeout time.Duration) {
+	if db == nil && client == nil {
+		return
+	}
+	time.AfterFunc(timeout, func() {
+		if err := closeSource(db, client); err != nil {
+			zap.L().Warn("close drained source failed", zap.String("source", name), zap.Error(err))
+		}
+	})
+}
+
+func isRedisDSN(dsn string) bool {
+	for _, scheme := range []string{"redis://", "rediss://", "unix://"} {
+		if strings.HasPrefix(dsn, scheme) {
+			return true
+		}
+	}
+	return false
+}
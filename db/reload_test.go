@@ -0,0 +1,199 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/glebarez/sqlite"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// useSqlite 让 mysql 数据源和从库打开 DSN 对应的 sqlite 文件
+func useSqlite(t *testing.T) {
+	openDialector = func(cfg SourceConfig) (gorm.Dialector, error) {
+		return sqlite.Open(cfg.DSN), nil
+	}
+	t.Cleanup(func() { openDialector = newDialector })
+}
+
+func newReloadTestManager(t *testing.T, sources ...SourceConfig) *DBManager {
+	dbManager := NewDBManager()
+	if err := dbManager.ReloadConfig(&Config{Sources: sources}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dbManager.Close() })
+	return dbManager
+}
+
+// newMemoryTestManager default 数据源为单连接的内存 sqlite 和 miniredis，stmts 用来建表和写入数据
+func newMemoryTestManager(t *testing.T, stmts ...string) (*DBManager, *miniredis.Miniredis) {
+	useSqlite(t)
+	mr := miniredis.RunT(t)
+	dbManager := newReloadTestManager(t,
+		SourceConfig{Name: "default", Type: TypeMysql, DSN: ":memory:", MaxOpenConns: 1},
+		SourceConfig{Name: "default", Type: TypeRedis, DSN: "redis://" + mr.Addr()},
+	)
+	db := dbManager.GetGorm("default")
+	for _, stmt := range stmts {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dbManager, mr
+}
+
+func TestReloadConcurrent(t *testing.T) {
+	useSqlite(t)
+	dir := t.TempDir()
+	mr1, mr2 := miniredis.RunT(t), miniredis.RunT(t)
+	dbManager := newReloadTestManager(t,
+		SourceConfig{Name: "default", Type: TypeMysql, DSN: filepath.Join(dir, "0.db")},
+		SourceConfig{Name: "default", Type: TypeRedis, DSN: "redis://" + mr1.Addr()},
+	)
+	dbManager.SetDrainTimeout(time.Second)
+
+	// 热更新期间并发获取连接，旧连接在 drain 之前仍然可用
+	var (
+		stop   atomic.Bool
+		wg     sync.WaitGroup
+		served atomic.Int64
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !stop.Load() {
+				db := dbManager.GetGorm("default")
+				if db == nil {
+					t.Error("gorm is nil")
+					return
+				}
+				var n int
+				if err := db.Raw("SELECT 1").Scan(&n).Error; err != nil {
+					t.Error(err)
+					return
+				}
+				client := dbManager.GetRedisClient("default")
+				if client == nil {
+					t.Error("redis is nil")
+					return
+				}
+				if err := client.Ping(context.Background()).Err(); err != nil {
+					t.Error(err)
+					return
+				}
+				served.Add(1)
+			}
+		}()
+	}
+
+	for i := 1; i <= 10; i++ {
+		if err := dbManager.Reload("default", filepath.Join(dir, strings.Repeat("x", i)+".db")); err != nil {
+			t.Fatal(err)
+		}
+		mr := mr1
+		if i%2 == 1 {
+			mr = mr2
+		}
+		if err := dbManager.Reload("default", "redis://"+mr.Addr()); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	stop.Store(true)
+	wg.Wait()
+	if served.Load() == 0 {
+		t.Error("no request served")
+	}
+
+	dbManager.mu.RLock()
+	dsn := dbManager.configs[sourceKey(TypeMysql, "default")].DSN
+	dbManager.mu.RUnlock()
+	if want := filepath.Join(dir, strings.Repeat("x", 10)+".db"); dsn != want {
+		t.Errorf("dsn = %s, want %s", dsn, want)
+	}
+	if err := dbManager.Reload("missing", "redis://"+mr1.Addr()); err == nil {
+		t.Error("reload of unknown source should fail")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := db.DB()
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+
+	// 超时之前旧连接继续可用，之后被关闭
+	drain("default", db, client, 50*time.Millisecond)
+	if err := sqlDB.Ping(); err != nil {
+		t.Fatalf("ping before timeout: %v", err)
+	}
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		t.Fatalf("redis ping before timeout: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for sqlDB.Ping() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("old pool not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := client.Ping(context.Background()).Err(); err != redis.ErrClosed {
+		t.Errorf("redis err = %v, want ErrClosed", err)
+	}
+}
+
+func TestReloadConfigRemove(t *testing.T) {
+	useSqlite(t)
+	dir := t.TempDir()
+	mr := miniredis.RunT(t)
+	sources := []SourceConfig{
+		{Name: "default", Type: TypeMysql, DSN: filepath.Join(dir, "default.db")},
+		{Name: "shard_0", Type: TypeMysql, DSN: filepath.Join(dir, "shard_0.db")},
+		{Name: "shard_1", Type: TypeMysql, DSN: filepath.Join(dir, "shard_1.db")},
+		{Name: "default", Type: TypeRedis, DSN: "redis://" + mr.Addr()},
+	}
+	dbManager := newReloadTestManager(t, sources...)
+	dbManager.SetDrainTimeout(0)
+	if err := dbManager.RegisterShardGroup("player", NewModuloStrategy("shard_0", "shard_1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 删除 redis 和分片组还在使用的 shard_1，只有 redis 被移除
+	old := dbManager.GetGorm("default")
+	if err := dbManager.ReloadConfig(&Config{Sources: sources[:2]}); err == nil || !strings.Contains(err.Error(), "shard group player") {
+		t.Fatalf("err = %v", err)
+	}
+	if dbManager.GetUniversalRedis("default") != nil {
+		t.Error("redis should be removed")
+	}
+	if dbManager.GetShard("player", 1) == nil {
+		t.Error("shard_1 should be kept")
+	}
+	if dbManager.GetGorm("default") != old {
+		t.Error("unchanged source should not be reopened")
+	}
+
+	// 分片组不再使用后可以移除
+	if err := dbManager.RegisterShardGroup("player", NewModuloStrategy("shard_0")); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbManager.ReloadConfig(&Config{Sources: sources[:2]}); err != nil {
+		t.Fatal(err)
+	}
+	if dbManager.GetGorm("shard_1") != nil {
+		t.Error("shard_1 should be removed")
+	}
+	if dbManager.GetShard("player", 1) == nil {
+		t.Error("player shard should still work")
+	}
+}
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand/v2"
+
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// ReplicaConfig 从库配置，Weight 只在加权路由时生效
+type ReplicaConfig struct {
+	DSN    string `yaml:"dsn"`
+	Weight int    `yaml:"weight"`
+}
+
+// WithReplicas 添加从库，读请求在从库之间随机路由
+func WithReplicas(dsns ...string) Option {
+	return func(cfg *SourceConfig) {
+		for _, dsn := range dsns {
+			cfg.Replicas = append(cfg.Replicas, ReplicaConfig{DSN: dsn})
+		}
+	}
+}
+
+// WithWeightedReplica 添加带权重的从库，只要有一个从库配置了权重就按权重路由
+func WithWeightedReplica(dsn string, weight int) Option {
+	return func(cfg *SourceConfig) {
+		cfg.Replicas = append(cfg.Replicas, ReplicaConfig{DSN: dsn, Weight: weight})
+	}
+}
+
+type primaryKey struct{}
+
+// WithPrimary 标记 ctx 中的读请求强制走主库，用于写完立即读的场景
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// IsPrimary ctx 是否被标记为强制走主库
+func IsPrimary(ctx context.Context) bool {
+	force, _ := ctx.Value(primaryKey{}).(bool)
+	return force
+}
+
+// GetGormContext 获取绑定了 ctx 的 gorm，ctx 经过 WithPrimary 标记时读请求也走主库，
+// ctx 中有 WithTx 开启的事务时返回该事务
+func (dbManager *DBManager) GetGormContext(ctx context.Context, name string) *gorm.DB {
+	if tx, ok := TxFromContext(ctx, name); ok {
+		return tx
+	}
+
+	db := dbManager.GetGorm(name)
+	if db == nil {
+		return nil
+	}
+
+	db = db.WithContext(ctx)
+	if IsPrimary(ctx) {
+		db = db.Clauses(dbresolver.Write)
+	}
+	return db
+}
+
+// useReplicas 给主库注册从库，写请求和事务始终在主库上执行
+func useReplicas(db *gorm.DB, cfg SourceConfig) error {
+	var (
+		dialectors = make([]gorm.Dialector, 0, len(cfg.Replicas))
+		weights    = make([]int, 0, len(cfg.Replicas))
+		weighted   bool
+	)
+	for _, replica := range cfg.Replicas {
+		replicaCfg := cfg
+		replicaCfg.DSN = replica.DSN
+		dialector, err := openDialector(replicaCfg)
+		if err != nil {
+			return fmt.Errorf("replica: %w", err)
+		}
+		dialectors = append(dialectors, dialector)
+		weights = append(weights, replica.Weight)
+		if replica.Weight > 0 {
+			weighted = true
+		}
+	}
+
+	var policy dbresolver.Policy = dbresolver.RandomPolicy{}
+	if weighted {
+		policy = newWeightedPolicy(weights)
+	}
+
+	resolver := dbresolver.Register(dbresolver.Config{Replicas: dialectors, Policy: policy})
+	if cfg.MaxOpenConns > 0 {
+		resolver.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		resolver.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		resolver.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+	if cfg.ConnMaxIdleTime > 0 {
+		resolver.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	}
+	if err := db.Use(resolver); err != nil {
+		return err
+	}
+
+	for _, sqlDB := range sqlDBs(db)[1:] {
+		if err := pingWithRetry(cfg, sqlDB.PingContext); err != nil {
+			return fmt.Errorf("failed to connect replica: %w", err)
+		}
+	}
+	return nil
+}
+
+// sqlDBs 返回 gorm 使用的所有连接池，第一个是主库，其余是从库
+func sqlDBs(db *gorm.DB) []*sql.DB {
+	var pools []*sql.DB
+	primary, err := db.DB()
+	if err != nil {
+		return nil
+	}
+	pools = append(pools, primary)
+
+	plugin, ok := db.Config.Plugins[(&dbresolver.DBResolver{}).Name()]
+	if !ok {
+		return pools
+	}
+	plugin.(*dbresolver.DBResolver).Call(func(connPool gorm.ConnPool) error {
+		if sqlDB, ok := connPool.(*sql.DB); ok && sqlDB != primary {
+			pools = append(pools, sqlDB)
+		}
+		return nil
+	})
+	return pools
+}
+
+// weightedPolicy 按权重随机选择从库，权重小于等于 0 的从库按 1 计算
+type weightedPolicy struct {
+	weights []int
+	total   int
+}
+
+func newWeightedPolicy(weights []int) *weightedPolicy {
+	p := &weightedPolicy{weights: make([]int, len(weights))}
+	for i, w := range weights {
+		p.weights[i] = max(w, 1)
+		p.total += p.weights[i]
+	}
+	return p
+}
+
+func (p *weightedPolicy) Resolve(connPools []gorm.ConnPool) gorm.ConnPool {
+	n := rand.IntN(p.total)
+	for i, w := range p.weights {
+		if n < w && i < len(connPools) {
+			return connPools[i]
+		}
+		n -= w
+	}
+	return connPools[len(connPools)-1]
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// newReplicaTestManager 每个库的 nodes 表里写入自己的名字，用来区分查询落在哪个连接池
+func newReplicaTestManager(t *testing.T, replicas ...ReplicaConfig) *DBManager {
+	useSqlite(t)
+	dir := t.TempDir()
+	create := func(name string) string {
+		dsn := filepath.Join(dir, name+".db")
+		db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Exec("CREATE TABLE nodes (name TEXT)")
+		db.Exec("INSERT INTO nodes (name) VALUES (?)", name)
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+		return dsn
+	}
+
+	source := SourceConfig{Name: "default", Type: TypeMysql, DSN: create("primary")}
+	for i, replica := range replicas {
+		replica.DSN = create(replica.DSN)
+		replicas[i] = replica
+	}
+	source.Replicas = replicas
+	return newReloadTestManager(t, source)
+}
+
+func queryNode(t *testing.T, db *gorm.DB) string {
+	t.Helper()
+	var name string
+	if err := db.Raw("SELECT name FROM nodes ORDER BY rowid LIMIT 1").Scan(&name).Error; err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestReplicaRouting(t *testing.T) {
+	dbManager := newReplicaTestManager(t, ReplicaConfig{DSN: "replica"})
+	ctx := context.Background()
+
+	if pools := sqlDBs(dbManager.GetGorm("default")); len(pools) != 2 {
+		t.Fatalf("got %d pools, want 2", len(pools))
+	}
+	if got := queryNode(t, dbManager.GetGormContext(ctx, "default")); got != "replica" {
+		t.Errorf("read served by %s, want replica", got)
+	}
+	if got := queryNode(t, dbManager.GetGormContext(WithPrimary(ctx), "default")); got != "primary" {
+		t.Errorf("WithPrimary read served by %s, want primary", got)
+	}
+
+	// 写请求和事务走主库
+	if err := dbManager.GetGormContext(ctx, "default").Exec("INSERT INTO nodes (name) VALUES ('written')").Error; err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	dbManager.GetGormContext(WithPrimary(ctx), "default").Raw("SELECT COUNT(*) FROM nodes").Scan(&count)
+	if count != 2 {
+		t.Errorf("primary has %d rows, want 2", count)
+	}
+	err := dbManager.WithTx(ctx, "default", nil, func(ctx context.Context, tx *gorm.DB) error {
+		if got := queryNode(t, dbManager.GetGormContext(ctx, "default")); got != "primary" {
+			t.Errorf("read in tx served by %s, want primary", got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWeightedReplicas(t *testing.T) {
+	dbManager := newReplicaTestManager(t,
+		ReplicaConfig{DSN: "light", Weight: 1},
+		ReplicaConfig{DSN: "heavy", Weight: 3},
+	)
+	db := dbManager.GetGormContext(context.Background(), "default")
+
+	counts := make(map[string]int)
+	const n = 2000
+	for i := 0; i < n; i++ {
+		counts[queryNode(t, db)]++
+	}
+	if counts["primary"] != 0 || counts["light"]+counts["heavy"] != n {
+		t.Fatalf("counts = %v", counts)
+	}
+	// 期望 1:3，留出足够的随机误差
+	if light := counts["light"]; light < n/4-150 || light > n/4+150 {
+		t.Errorf("light replica served %d of %d, want about %d", light, n, n/4)
+	}
+}
+
+func TestWeightedPolicy(t *testing.T) {
+	pools := []gorm.ConnPool{&gorm.PreparedStmtDB{}, &gorm.PreparedStmtDB{}}
+	// 权重小于等于 0 按 1 计算
+	p := newWeightedPolicy([]int{0, 1})
+	counts := make(map[gorm.ConnPool]int)
+	for i := 0; i < 1000; i++ {
+		counts[p.Resolve(pools)]++
+	}
+	if counts[pools[0]] < 400 || counts[pools[1]] < 400 {
+		t.Errorf("counts = %d %d", counts[pools[0]], counts[pools[1]])
+	}
+
+	// 从库数量少于权重数量时不越界
+	p = newWeightedPolicy([]int{1, 1, 100})
+	for i := 0; i < 100; i++ {
+		if pool := p.Resolve(pools[:1]); pool != pools[0] {
+			t.Fatal("resolved unknown pool")
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package db
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+// ShardStrategy 分片策略，根据 uid 选择数据源名称
+type ShardStrategy interface {
+	Select(uid uint64) string
+	Shards() []string // 分片用到的所有数据源，注册时检查是否已经初始化
+}
+
+// RegisterShardGroup 注册分片组，分片用到的数据源需要先通过 Init 等方法初始化
+//
+//	dbManager.RegisterShardGroup("player", db.NewModuloStrategy("shard_0", "shard_1", "shard_2"))
+//	db := dbManager.GetShard("player", helper.GetUid(ctx))
+func (dbManager *DBManager) RegisterShardGroup(group string, strategy ShardStrategy) error {
+	shards := strategy.Shards()
+	if len(shards) == 0 {
+		return fmt.Errorf("shard group %s: no shards", group)
+	}
+
+	dbManager.mu.Lock()
+	defer dbManager.mu.Unlock()
+	for _, name := range shards {
+		if _, ok := dbManager.dbMap[name]; !ok {
+			return fmt.Errorf("shard group %s: source %s not found", group, name)
+		}
+	}
+	dbManager.shardGroups[group] = strategy
+	return nil
+}
+
+// GetShardName 获取 uid 所在分片的数据源名称
+func (dbManager *DBManager) GetShardName(group string, uid uint64) (string, error) {
+	dbManager.mu.RLock()
+	strategy, ok := dbManager.shardGroups[group]
+	dbManager.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("shard group %s not found", group)
+	}
+
+	name := strategy.Select(uid)
+	if name == "" {
+		return "", fmt.Errorf("shard group %s: no shard for uid %d", group, uid)
+	}
+	return name, nil
+}
+
+// GetShard 获取 uid 所在分片的 gorm，分片组不存在或 uid 不在任何分片时返回 nil
+func (dbManager *DBManager) GetShard(group string, uid uint64) *gorm.DB {
+	name, err := dbManager.GetShardName(group, uid)
+	if err != nil {
+		return nil
+	}
+	return dbManager.GetGorm(name)
+}
+
+// GetShardContext 同 GetShard，支持 WithPrimary 和 WithTx
+func (dbManager *DBManager) GetShardContext(ctx context.Context, group string, uid uint64) *gorm.DB {
+	name, err := dbManager.GetShardName(group, uid)
+	if err != nil {
+		return nil
+	}
+	return dbManager.GetGormContext(ctx, name)
+}
+
+// ModuloStrategy 按 uid % 分片数 选择分片，扩容时大部分数据需要迁移
+type ModuloStrategy struct {
+	shards []string
+}
+
+func NewModuloStrategy(shards ...string) *ModuloStrategy {
+	return &ModuloStrategy{shards: shards}
+}
+
+func (s *ModuloStrategy) Select(uid uint64) string {
+	if len(s.shards) == 0 {
+		return ""
+	}
+	return s.shards[uid%uint64(len(s.shards))]
+}
+
+func (s *ModuloStrategy) Shards() []string {
+	return s.shards
+}
+
+// ConsistentHashStrategy 一致性哈希，增减分片时只有少量 uid 会换分片
+type ConsistentHashStrategy struct {
+	shards []string
+	hashes []uint64
+	nodes  map[uint64]string
+}
+
+const defaultVirtualNodes = 160
+
+// NewConsistentHashStrategy virtualNodes 为每个分片的虚拟节点数，小于等于 0 时默认 160
+func NewConsistentHashStrategy(virtualNodes int, shards ...string) *ConsistentHashStrategy {
+	if virtualNodes <= 0 {
+		virtualNodes = defaultVirtualNodes
+	}
+
+	s := &ConsistentHashStrategy{shards: shards, nodes: make(map[uint64]string)}
+	for _, shard := range shards {
+		for i := 0; i < virtualNodes; i++ {
+			h := hashString(shard + "#" + strconv.Itoa(i))
+			if _, ok := s.nodes[h]; ok {
+				continue
+			}
+			s.nodes[h] = shard
+			s.hashes = append(s.hashes, h)
+		}
+	}
+	sort.Slice(s.hashes, func(i, j int) bool { return s.hashes[i] < s.hashes[j] })
+	return s
+}
+
+func (s *ConsistentHashStrategy) Select(uid uint64) string {
+	if len(s.hashes) == 0 {
+		return ""
+	}
+
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uid)
+	h := hashBytes(buf[:])
+	i := sort.Search(len(s.hashes), func(i int) bool { return s.hashes[i] >= h })
+	if i == len(s.hashes) {
+		i = 0
+	}
+	return s.nodes[s.hashes[i]]
+}
+
+func (s *ConsistentHashStrategy) Shards() []string {
+	return s.shards
+}
+
+// ShardRange uid 范围 [Start, End) 对应的数据源
+type ShardRange struct {
+	Start  uint64
+	End    uint64
+	Source string
+}
+
+// RangeStrategy 按 uid 范围选择分片，适合 uid 自增、按号段分库的场景
+type RangeStrategy struct {
+	ranges []ShardRange
+}
+
+// NewRangeStrategy 范围之间不能重叠
+func NewRangeStrategy(ranges ...ShardRange) (*RangeStrategy, error) {
+	sorted := make([]ShardRange, len(ranges))
+	copy(sorted, ranges)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+
+	for i, r := range sorted {
+		if r.Start >= r.End {
+			return nil, fmt.Errorf("invalid shard range [%d, %d)", r.Start, r.End)
+		}
+		if i > 0 && sorted[i-1].End > r.Start {
+			return nil, fmt.Errorf("shard range [%d, %d) overlaps [%d, %d)", r.Start, r.End, sorted[i-1].Start, sorted[i-1].End)
+		}
+	}
+	return &RangeStrategy{ranges: sorted}, nil
+}
+
+func (s *RangeStrategy) Select(uid uint64) string {
+	i := sort.Search(len(s.ranges), func(i int) bool { return s.ranges[i].End > uid })
+	if i == len(s.ranges) || s.ranges[i].Start > uid {
+		return ""
+	}
+	return s.ranges[i].Source
+}
+
+func (s *RangeStrategy) Shards() []string {
+	var shards []string
+	seen := make(map[string]bool)
+	for _, r := range s.ranges {
+		if !seen[r.Source] {
+			seen[r.Source] = true
+			shards = append(shards, r.Source)
+		}
+	}
+	return shards
+}
+
+func hashString(s string) uint64 {
+	return hashBytes([]byte(s))
+}
+
+// hashBytes fnv 对只有末尾几个字节不同的输入分布很差，再用 murmur3 的 fmix64 打散
+func hashBytes(b []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(b)
+	k := h.Sum64()
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+	return k
+}
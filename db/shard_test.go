@@ -0,0 +1,75 @@
+package db
+
+import (
+	"testing"
+)
+
+func TestModuloStrategy(t *testing.T) {
+	s := NewModuloStrategy("shard_0", "shard_1", "shard_2")
+	if got := s.Select(10); got != "shard_1" {
+		t.Errorf("Select(10) = %s, want shard_1", got)
+	}
+}
+
+func TestConsistentHashStrategy(t *testing.T) {
+	s := NewConsistentHashStrategy(0, "shard_0", "shard_1", "shard_2")
+	counts := make(map[string]int)
+	for uid := uint64(0); uid < 30000; uid++ {
+		counts[s.Select(uid)]++
+	}
+	for _, shard := range s.Shards() {
+		if counts[shard] < 5000 {
+			t.Errorf("shard %s got %d uids, distribution too uneven: %v", shard, counts[shard], counts)
+		}
+	}
+
+	// 增加一个分片后，大部分 uid 仍然在原来的分片
+	expanded := NewConsistentHashStrategy(0, "shard_0", "shard_1", "shard_2", "shard_3")
+	moved := 0
+	for uid := uint64(0); uid < 30000; uid++ {
+		if before, after := s.Select(uid), expanded.Select(uid); before != after {
+			if after != "shard_3" {
+				t.Fatalf("uid %d moved from %s to %s", uid, before, after)
+			}
+			moved++
+		}
+	}
+	if moved > 30000/2 {
+		t.Errorf("too many uids moved: %d", moved)
+	}
+}
+
+func TestRangeStrategy(t *testing.T) {
+	s, err := NewRangeStrategy(
+		ShardRange{Start: 1000000, End: 2000000, Source: "shard_1"},
+		ShardRange{Start: 0, End: 1000000, Source: "shard_0"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[uint64]string{0: "shard_0", 999999: "shard_0", 1000000: "shard_1", 2000000: ""}
+	for uid, want := range cases {
+		if got := s.Select(uid); got != want {
+			t.Errorf("Select(%d) = %q, want %q", uid, got, want)
+		}
+	}
+
+	_, err = NewRangeStrategy(
+		ShardRange{Start: 0, End: 100, Source: "shard_0"},
+		ShardRange{Start: 50, End: 200, Source: "shard_1"},
+	)
+	if err == nil {
+		t.Error("expected overlap error")
+	}
+}
+
+func TestRegisterShardGroup(t *testing.T) {
+	dbManager := NewDBManager()
+	if err := dbManager.RegisterShardGroup("player", NewModuloStrategy("shard_0")); err == nil {
+		t.Error("expected error for uninitialized source")
+	}
+	if db := dbManager.GetShard("player", 1); db != nil {
+		t.Error("unknown group should return nil")
+	}
+}
@@ -0,0 +1,182 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// 使用全局的 otel TracerProvider 和 MeterProvider，需要在 Init 之前设置好
+const instrumentationName = "github.com/zuodazuoqianggame/common/db"
+
+// telemetry 一个数据源的 span 和指标
+type telemetry struct {
+	source   string
+	system   string
+	tracer   trace.Tracer
+	duration metric.Float64Histogram
+	errors   metric.Int64Counter
+}
+
+func newTelemetry(source string, system string, prefix string) (*telemetry, error) {
+	meter := otel.Meter(instrumentationName)
+	duration, err := meter.Float64Histogram(prefix+".client.duration",
+		metric.WithDescription("Duration of "+prefix+" operations"), metric.WithUnit("s"))
+	if err != nil {
+		return nil, err
+	}
+	errCounter, err := meter.Int64Counter(prefix+".client.errors",
+		metric.WithDescription("Number of failed "+prefix+" operations"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &telemetry{
+		source:   source,
+		system:   system,
+		tracer:   otel.Tracer(instrumentationName),
+		duration: duration,
+		errors:   errCounter,
+	}, nil
+}
+
+func (t *telemetry) start(ctx context.Context, spanName string) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, spanName, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", t.system), attribute.String("db.source", t.source)))
+}
+
+func (t *telemetry) finish(ctx context.Context, span trace.Span, begin time.Time, err error, attrs ...attribute.KeyValue) {
+	attrs = append(attrs, attribute.String("db.source", t.source))
+	span.SetAttributes(attrs...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		t.errors.Add(ctx, 1, metric.WithAttributes(attrs...))
+	}
+	span.End()
+	t.duration.Record(ctx, time.Since(begin).Seconds(), metric.WithAttributes(attrs...))
+}
+
+// gormTelemetry gorm 插件，每条语句一个 span，按数据源、操作和表记录耗时
+type gormTelemetry struct {
+	*telemetry
+}
+
+const (
+	telemetrySpanKey  = "telemetry:span"
+	telemetryBeginKey = "telemetry:begin"
+)
+
+func newGormTelemetry(source string, system string) (*gormTelemetry, error) {
+	t, err := newTelemetry(source, system, "db")
+	if err != nil {
+		return nil, err
+	}
+	return &gormTelemetry{t}, nil
+}
+
+func (p *gormTelemetry) Name() string {
+	return "common:telemetry"
+}
+
+func (p *gormTelemetry) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+	return errors.Join(
+		callback.Create().Before("gorm:create").Register("telemetry:before_create", p.before("create")),
+		callback.Create().After("gorm:create").Register("telemetry:after_create", p.after("create")),
+		callback.Query().Before("gorm:query").Register("telemetry:before_query", p.before("query")),
+		callback.Query().After("gorm:query").Register("telemetry:after_query", p.after("query")),
+		callback.Update().Before("gorm:update").Register("telemetry:before_update", p.before("update")),
+		callback.Update().After("gorm:update").Register("telemetry:after_update", p.after("update")),
+		callback.Delete().Before("gorm:delete").Register("telemetry:before_delete", p.before("delete")),
+		callback.Delete().After("gorm:delete").Register("telemetry:after_delete", p.after("delete")),
+		callback.Row().Before("gorm:row").Register("telemetry:before_row", p.before("row")),
+		callback.Row().After("gorm:row").Register("telemetry:after_row", p.after("row")),
+		callback.Raw().Before("gorm:raw").Register("telemetry:before_raw", p.before("raw")),
+		callback.Raw().After("gorm:raw").Register("telemetry:after_raw", p.after("raw")),
+	)
+}
+
+func (p *gormTelemetry) before(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx, span := p.start(db.Statement.Context, "gorm."+operation)
+		db.Statement.Context = ctx
+		db.InstanceSet(telemetrySpanKey, span)
+		db.InstanceSet(telemetryBeginKey, time.Now())
+	}
+}
+
+func (p *gormTelemetry) after(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(telemetrySpanKey)
+		if !ok {
+			return
+		}
+		span := value.(trace.Span)
+		value, _ = db.InstanceGet(telemetryBeginKey)
+		begin, _ := value.(time.Time)
+
+		err := db.Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = nil
+		}
+		span.SetAttributes(attribute.String("db.statement", db.Statement.SQL.String()),
+			attribute.Int64("db.rows_affected", db.Statement.RowsAffected))
+		p.finish(db.Statement.Context, span, begin, err,
+			attribute.String("db.operation", operation), attribute.String("db.table", db.Statement.Table))
+	}
+}
+
+// redisTelemetry redis 钩子，每个命令（pipeline 整体）一个 span，按数据源和命令记录耗时
+type redisTelemetry struct {
+	*telemetry
+}
+
+func newRedisTelemetry(source string) (*redisTelemetry, error) {
+	t, err := newTelemetry(source, "redis", "redis")
+	if err != nil {
+		return nil, err
+	}
+	return &redisTelemetry{t}, nil
+}
+
+func (h *redisTelemetry) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *redisTelemetry) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		ctx, span := h.start(ctx, "redis."+cmd.Name())
+		begin := time.Now()
+		err := next(ctx, cmd)
+		h.finish(ctx, span, begin, redisError(err), attribute.String("db.operation", cmd.Name()))
+		return err
+	}
+}
+
+func (h *redisTelemetry) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		ctx, span := h.start(ctx, "redis.pipeline")
+		span.SetAttributes(attribute.Int("db.redis.num_cmd", len(cmds)))
+		begin := time.Now()
+		err := next(ctx, cmds)
+		h.finish(ctx, span, begin, redisError(err), attribute.String("db.operation", "pipeline"))
+		return err
+	}
+}
+
+// redisError redis.Nil 表示 key 不存在，不算错误
+func redisError(err error) error {
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	return err
+}
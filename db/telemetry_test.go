@@ -0,0 +1,131 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/glebarez/sqlite"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gorm.io/gorm"
+)
+
+func TestGormTelemetry(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	otel.SetTracerProvider(provider)
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugin, err := newGormTelemetry("test", db.Dialector.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Use(plugin); err != nil {
+		t.Fatal(err)
+	}
+
+	type Player struct {
+		ID   int
+		Name string
+	}
+	db.Exec("CREATE TABLE players (id INTEGER PRIMARY KEY, name TEXT)")
+	db.Create(&Player{ID: 1, Name: "a"})
+	var p Player
+	db.First(&p, 2) // record not found 不算错误
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+	last := spans[2]
+	if last.Name() != "gorm.query" || last.Status().Code != 0 {
+		t.Errorf("unexpected span %s %v", last.Name(), last.Status())
+	}
+	var table string
+	for _, attr := range last.Attributes() {
+		if attr.Key == "db.table" {
+			table = attr.Value.AsString()
+		}
+	}
+	if table != "players" {
+		t.Errorf("db.table = %q, want players", table)
+	}
+}
+
+func TestRedisTelemetry(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	defer otel.SetMeterProvider(otel.GetMeterProvider())
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), PoolSize: 1})
+	defer client.Close()
+	ctx := context.Background()
+	// 先建立连接，握手时的 HELLO 等命令不计入
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Fatal(err)
+	}
+	hook, err := newRedisTelemetry("cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.AddHook(hook)
+
+	client.Set(ctx, "a", "1", 0)
+	client.Get(ctx, "missing") // redis.Nil 不算错误
+	pipe := client.Pipeline()
+	pipe.Get(ctx, "a")
+	pipe.Get(ctx, "a")
+	pipe.Exec(ctx)
+	mr.SetError("boom")
+	client.Get(ctx, "a")
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(ctx, &rm); err != nil {
+		t.Fatal(err)
+	}
+	durations := make(map[string]uint64)
+	errorCounts := make(map[string]int64)
+	for _, scope := range rm.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Histogram[float64]:
+				if m.Name != "redis.client.duration" {
+					continue
+				}
+				for _, point := range data.DataPoints {
+					op, _ := point.Attributes.Value("db.operation")
+					if source, _ := point.Attributes.Value("db.source"); source.AsString() != "cache" {
+						t.Errorf("db.source = %v", source)
+					}
+					durations[op.AsString()] += point.Count
+				}
+			case metricdata.Sum[int64]:
+				if m.Name != "redis.client.errors" {
+					continue
+				}
+				for _, point := range data.DataPoints {
+					op, _ := point.Attributes.Value("db.operation")
+					errorCounts[op.AsString()] += point.Value
+				}
+			}
+		}
+	}
+
+	if want := map[string]uint64{"set": 1, "get": 2, "pipeline": 1}; !reflect.DeepEqual(durations, want) {
+		t.Errorf("redis.client.duration counts = %v, want %v", durations, want)
+	}
+	if want := map[string]int64{"get": 1}; !reflect.DeepEqual(errorCounts, want) {
+		t.Errorf("redis.client.errors = %v, want %v", errorCounts, want)
+	}
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/zuodazuoqianggame/common/utils"
+	"gorm.io/gorm"
+)
+
+// TxOptions 事务选项，nil 时使用默认值
+type TxOptions struct {
+	Isolation   sql.IsolationLevel // 隔离级别，默认使用数据库的默认级别
+	ReadOnly    bool               // 只读事务
+	MaxRetries  int                // 死锁、序列化失败时的最大重试次数，默认 3，小于 0 不重试
+	BaseBackoff time.Duration      // 首次重试的退避时间，默认 20ms，之后翻倍并加随机抖动
+	MaxBackoff  time.Duration      // 最大退避时间，默认 1s
+}
+
+const (
+	defaultTxRetries     = 3
+	defaultTxBaseBackoff = 20 * time.Millisecond
+	defaultTxMaxBackoff  = time.Second
+)
+
+type txKey struct {
+	name string
+}
+
+// TxFromContext 获取 ctx 中 name 数据源正在进行的事务
+func TxFromContext(ctx context.Context, name string) (*gorm.DB, bool) {
+	tx, ok := ctx.Value(txKey{name}).(*gorm.DB)
+	return tx, ok
+}
+
+// WithTx 在 name 数据源上执行事务，fn 返回错误时回滚
+//
+// fn 收到的 ctx 中携带了事务，内层通过 GetGormContext 或再次调用 WithTx 会加入同一个事务，
+// 只有最外层的 WithTx 负责提交和重试。遇到 mysql 死锁 (1213)、锁等待超时 (1205)、
+// pgsql 序列化失败 (40001)、死锁 (40P01) 时整个事务按指数退避重试，fn 需要可以重复执行
+func (dbManager *DBManager) WithTx(ctx context.Context, name string, opts *TxOptions, fn func(ctx context.Context, tx *gorm.DB) error) error {
+	if tx, ok := TxFromContext(ctx, name); ok {
+		return fn(ctx, tx)
+	}
+
+	db := dbManager.GetGorm(name)
+	if db == nil {
+		return fmt.Errorf("source %s not found", name)
+	}
+
+	if opts == nil {
+		opts = &TxOptions{}
+	}
+	retries := opts.MaxRetries
+	if retries == 0 {
+		retries = defaultTxRetries
+	}
+	backoff := opts.BaseBackoff
+	if backoff <= 0 {
+		backoff = defaultTxBaseBackoff
+	}
+	maxBackoff := opts.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultTxMaxBackoff
+	}
+	sqlOpts := &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly}
+
+	for attempt := 0; ; attempt++ {
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return fn(context.WithValue(ctx, txKey{name}, tx), tx)
+		}, sqlOpts)
+		if err == nil || attempt >= retries || !IsRetryableTxError(err) {
+			return err
+		}
+
+		// 随机抖动，避免冲突的事务同时重试再次冲突
+		delay := backoff/2 + rand.N(backoff/2+1)
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(delay):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// IsRetryableTxError 是否是可以通过重试事务解决的错误
+func IsRetryableTxError(err error) bool {
+	if err == nil || utils.IsNoRecord(err) {
+		return false
+	}
+
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		// 1213: 死锁，1205: 锁等待超时
+		return mysqlErr.Number == 1213 || mysqlErr.Number == 1205
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// 40001: serialization_failure，40P01: deadlock_detected
+		return pgErr.Code == "40001" || pgErr.Code == "40P01"
+	}
+	return false
+}
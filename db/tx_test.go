@@ -0,0 +1,153 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+func TestIsRetryableTxError(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{gorm.ErrRecordNotFound, false},
+		{errors.New("boom"), false},
+		{&mysqldriver.MySQLError{Number: 1213}, true},
+		{fmt.Errorf("update item: %w", &mysqldriver.MySQLError{Number: 1205}), true},
+		{&mysqldriver.MySQLError{Number: 1062}, false},
+		{&pgconn.PgError{Code: "40001"}, true},
+		{&pgconn.PgError{Code: "40P01"}, true},
+		{&pgconn.PgError{Code: "23505"}, false},
+	}
+	for _, c := range cases {
+		if got := IsRetryableTxError(c.err); got != c.want {
+			t.Errorf("IsRetryableTxError(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestWithTxJoinsContextTx(t *testing.T) {
+	tx := &gorm.DB{}
+	ctx := context.WithValue(context.Background(), txKey{"default"}, tx)
+
+	// 已经在事务中时直接复用，不会去查找数据源
+	err := NewDBManager().WithTx(ctx, "default", nil, func(ctx context.Context, got *gorm.DB) error {
+		if got != tx {
+			t.Error("nested WithTx should join the outer tx")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewDBManager().WithTx(context.Background(), "missing", nil, nil); err == nil {
+		t.Error("expected error for missing source")
+	}
+}
+
+func committedAttempts(t *testing.T, dbManager *DBManager) []int {
+	t.Helper()
+	var attempts []int
+	if err := dbManager.GetGorm("default").Raw("SELECT attempt FROM items ORDER BY attempt").Scan(&attempts).Error; err != nil {
+		t.Fatal(err)
+	}
+	return attempts
+}
+
+func TestWithTxRetry(t *testing.T) {
+	deadlock := &mysqldriver.MySQLError{Number: 1213}
+	opts := &TxOptions{MaxRetries: 3, BaseBackoff: time.Millisecond}
+
+	cases := []struct {
+		name      string
+		failures  int
+		wantErr   error
+		wantCalls int
+		committed []int
+	}{
+		{"succeed after retries", 3, nil, 4, []int{4}},
+		{"exceed max retries", 4, deadlock, 4, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dbManager, _ := newMemoryTestManager(t, "CREATE TABLE items (attempt INTEGER)")
+			calls := 0
+			err := dbManager.WithTx(context.Background(), "default", opts, func(ctx context.Context, tx *gorm.DB) error {
+				calls++
+				// 每次都先写入，失败的尝试需要回滚
+				if err := tx.Exec("INSERT INTO items (attempt) VALUES (?)", calls).Error; err != nil {
+					return err
+				}
+				if calls <= c.failures {
+					return fmt.Errorf("update item: %w", deadlock)
+				}
+				return nil
+			})
+			if !errors.Is(err, c.wantErr) {
+				t.Fatalf("err = %v, want %v", err, c.wantErr)
+			}
+			if calls != c.wantCalls {
+				t.Errorf("fn called %d times, want %d", calls, c.wantCalls)
+			}
+			if got := committedAttempts(t, dbManager); fmt.Sprint(got) != fmt.Sprint(c.committed) {
+				t.Errorf("committed %v, want %v", got, c.committed)
+			}
+		})
+	}
+}
+
+func TestWithTxNoRetry(t *testing.T) {
+	dbManager, _ := newMemoryTestManager(t, "CREATE TABLE items (attempt INTEGER)")
+	ctx := context.Background()
+
+	// 不可重试的错误只执行一次
+	calls := 0
+	errFn := errors.New("boom")
+	if err := dbManager.WithTx(ctx, "default", nil, func(ctx context.Context, tx *gorm.DB) error {
+		calls++
+		return errFn
+	}); err != errFn || calls != 1 {
+		t.Errorf("err = %v, calls = %d", err, calls)
+	}
+
+	// ctx 取消时停止退避
+	ctx, cancel := context.WithCancel(ctx)
+	calls = 0
+	err := dbManager.WithTx(ctx, "default", &TxOptions{BaseBackoff: time.Hour}, func(ctx context.Context, tx *gorm.DB) error {
+		calls++
+		cancel()
+		return &pgconn.PgError{Code: "40001"}
+	})
+	if !errors.Is(err, context.Canceled) || calls != 1 {
+		t.Errorf("err = %v, calls = %d", err, calls)
+	}
+}
+
+func TestWithTxPanic(t *testing.T) {
+	dbManager, _ := newMemoryTestManager(t, "CREATE TABLE items (attempt INTEGER)")
+
+	// panic 时回滚并继续向上抛出
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("recover = %v", r)
+			}
+		}()
+		dbManager.WithTx(context.Background(), "default", nil, func(ctx context.Context, tx *gorm.DB) error {
+			tx.Exec("INSERT INTO items (attempt) VALUES (1)")
+			panic("boom")
+		})
+	}()
+	if got := committedAttempts(t, dbManager); len(got) != 0 {
+		t.Errorf("committed %v after panic", got)
+	}
+}
@@ -0,0 +1,151 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	klog "github.com/go-kratos/kratos/v2/log"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// 直接写入 zap 的 core，fatal、panic 级别不会在这里退出或 panic，由 kratos、logrus 自己处理
+
+// kratosLogger kratos 的 log.Logger 适配到 zap
+type kratosLogger struct {
+	core zapcore.Core
+}
+
+// NewKratosLogger 创建写入 logger 的 kratos log.Logger，logger 为 nil 时使用全局 logger，
+// 用于 ConnectionPool 等使用 kratos 日志的组件
+func NewKratosLogger(logger *zap.Logger) klog.Logger {
+	if logger == nil {
+		logger = zap.L()
+	}
+	return &kratosLogger{core: logger.Core()}
+}
+
+var kratosLevels = map[klog.Level]zapcore.Level{
+	klog.LevelDebug: zapcore.DebugLevel,
+	klog.LevelInfo:  zapcore.InfoLevel,
+	klog.LevelWarn:  zapcore.WarnLevel,
+	klog.LevelError: zapcore.ErrorLevel,
+	klog.LevelFatal: zapcore.FatalLevel,
+}
+
+func (l *kratosLogger) Log(level klog.Level, keyvals ...interface{}) error {
+	zapLevel, ok := kratosLevels[level]
+	if !ok {
+		zapLevel = zapcore.InfoLevel
+	}
+	if !l.core.Enabled(zapLevel) {
+		return nil
+	}
+
+	if len(keyvals)%2 != 0 {
+		keyvals = append(keyvals, "KEYVALS UNPAIRED")
+	}
+	var (
+		msg    string
+		fields = make([]zap.Field, 0, len(keyvals)/2)
+	)
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		if key == klog.DefaultMessageKey {
+			msg = fmt.Sprint(keyvals[i+1])
+			continue
+		}
+		fields = append(fields, zap.Any(key, keyvals[i+1]))
+	}
+	return write(l.core, zapcore.Entry{Level: zapLevel, Time: time.Now(), Message: msg}, fields)
+}
+
+// LogrusHook 把 logrus 的日志写入 zap
+type LogrusHook struct {
+	core zapcore.Core
+}
+
+// NewLogrusHook 创建写入 logger 的 logrus hook，logger 为 nil 时使用全局 logger
+func NewLogrusHook(logger *zap.Logger) *LogrusHook {
+	if logger == nil {
+		logger = zap.L()
+	}
+	return &LogrusHook{core: logger.Core()}
+}
+
+var logrusLevels = map[logrus.Level]zapcore.Level{
+	logrus.TraceLevel: zapcore.DebugLevel,
+	logrus.DebugLevel: zapcore.DebugLevel,
+	logrus.InfoLevel:  zapcore.InfoLevel,
+	logrus.WarnLevel:  zapcore.WarnLevel,
+	logrus.ErrorLevel: zapcore.ErrorLevel,
+	logrus.FatalLevel: zapcore.FatalLevel,
+	logrus.PanicLevel: zapcore.PanicLevel,
+}
+
+func (h *LogrusHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *LogrusHook) Fire(entry *logrus.Entry) error {
+	level := logrusLevels[entry.Level]
+	if !h.core.Enabled(level) {
+		return nil
+	}
+
+	keys := make([]string, 0, len(entry.Data))
+	for key := range entry.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fields := make([]zap.Field, 0, len(keys))
+	for _, key := range keys {
+		fields = append(fields, zap.Any(key, entry.Data[key]))
+	}
+
+	ent := zapcore.Entry{Level: level, Time: entry.Time, Message: entry.Message}
+	if entry.HasCaller() {
+		ent.Caller = zapcore.NewEntryCaller(entry.Caller.PC, entry.Caller.File, entry.Caller.Line, true)
+	}
+	return write(h.core, ent, fields)
+}
+
+// redirectHook 安装到 logrus 标准 logger 上的唯一 hook，再次调用 RedirectLogrus 时只替换写入的目标，
+// 不会重复输出，也不会继续写入已经关闭的旧日志
+type redirectHook struct {
+	target atomic.Pointer[LogrusHook]
+}
+
+func (h *redirectHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *redirectHook) Fire(entry *logrus.Entry) error {
+	return h.target.Load().Fire(entry)
+}
+
+var (
+	logrusRedirect     redirectHook
+	logrusRedirectOnce sync.Once
+)
+
+// RedirectLogrus 把 logrus 标准 logger 的日志全部写入 logger，不再输出到 stderr，
+// 日志级别由 logger 控制。多次调用时只写入最后一次的 logger
+func RedirectLogrus(logger *zap.Logger) {
+	logrusRedirect.target.Store(NewLogrusHook(logger))
+	logrusRedirectOnce.Do(func() { logrus.AddHook(&logrusRedirect) })
+	logrus.SetOutput(io.Discard)
+	logrus.SetLevel(logrus.TraceLevel)
+}
+
+func write(core zapcore.Core, ent zapcore.Entry, fields []zap.Field) error {
+	if ce := core.Check(ent, nil); ce != nil {
+		ce.Write(fields...)
+	}
+	return nil
+}
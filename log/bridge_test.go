@@ -0,0 +1,73 @@
+package log
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	klog "github.com/go-kratos/kratos/v2/log"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestKratosLogger(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	helper := klog.NewHelper(NewKratosLogger(zap.New(core).With(zap.String("app", "game"))))
+	helper.Debug("ignored")
+	helper.Infow("msg", "connected", "service", "match")
+	helper.Errorf("dial %s failed", "match")
+
+	entries := logs.All()
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if entries[0].Message != "connected" || fields["service"] != "match" || fields["app"] != "game" {
+		t.Errorf("unexpected entry %+v %v", entries[0].Entry, fields)
+	}
+	if entries[1].Level != zapcore.ErrorLevel || entries[1].Message != "dial match failed" {
+		t.Errorf("unexpected entry %+v", entries[1].Entry)
+	}
+}
+
+func TestLogrusHook(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	logger.AddHook(NewLogrusHook(zap.New(core)))
+
+	logger.WithField("uid", 10001).Warn("trie not loaded")
+	logger.Info("loaded")
+
+	entries := logs.All()
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries", len(entries))
+	}
+	if entries[0].Level != zapcore.WarnLevel || entries[0].ContextMap()["uid"] != int64(10001) {
+		t.Errorf("unexpected entry %+v %v", entries[0].Entry, entries[0].ContextMap())
+	}
+}
+
+func TestRedirectLogrus(t *testing.T) {
+	defer func() {
+		logrus.SetOutput(os.Stderr)
+		logrus.SetLevel(logrus.InfoLevel)
+	}()
+	first, firstLogs := observer.New(zap.InfoLevel)
+	second, secondLogs := observer.New(zap.InfoLevel)
+
+	// 多次调用只写入最后一次的 logger，不会重复输出
+	RedirectLogrus(zap.New(first))
+	RedirectLogrus(zap.New(second))
+	RedirectLogrus(zap.New(second))
+	logrus.Info("loaded")
+
+	if n := firstLogs.Len(); n != 0 {
+		t.Errorf("previous logger got %d entries", n)
+	}
+	if n := secondLogs.Len(); n != 1 {
+		t.Errorf("got %d entries, want 1", n)
+	}
+}
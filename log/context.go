@@ -0,0 +1,74 @@
+package log
+
+import (
+	"context"
+
+	"github.com/go-kratos/kratos/v2/metadata"
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/transport"
+	rpc "github.com/zuodazuoqianggame/common/utils/grpc"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+type loggerKey struct{}
+
+// 写入日志的全局 metadata，与 PRCHelper 读取的 key 一致
+var metadataFields = []struct {
+	key   string
+	field string
+}{
+	{"x-md-global-uid", "uid"},
+	{"x-md-global-appid", "appid"},
+	{"x-md-global-deviceId", "device_id"},
+	{"x-md-global-remote_ip", "remote_ip"},
+}
+
+// WithContext 把 logger 保存到 ctx 中
+func WithContext(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext 获取 ctx 中保存的 logger，没有时使用全局 logger 并加上 ContextFields
+func FromContext(ctx context.Context) *zap.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok {
+		return logger
+	}
+	return zap.L().With(ContextFields(ctx)...)
+}
+
+// ContextFields ctx 中的 trace id、span id 以及 uid、appid、deviceId 等请求 metadata
+func ContextFields(ctx context.Context) []zap.Field {
+	var fields []zap.Field
+	if spanCtx := trace.SpanContextFromContext(ctx); spanCtx.IsValid() {
+		fields = append(fields,
+			zap.String("trace_id", spanCtx.TraceID().String()),
+			zap.String("span_id", spanCtx.SpanID().String()))
+	}
+	if md, ok := metadata.FromServerContext(ctx); ok {
+		for _, f := range metadataFields {
+			if v, ok := rpc.GetMd(md, f.key); ok && v != "" {
+				fields = append(fields, zap.String(f.field, v))
+			}
+		}
+	}
+	return fields
+}
+
+// Server kratos 服务端中间件，把带有请求信息的 logger 保存到 ctx，之后通过 FromContext 获取。
+// 需要放在 tracing 和 metadata 中间件之后，logger 为 nil 时使用全局 logger
+func Server(logger *zap.Logger) middleware.Middleware {
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			l := logger
+			if l == nil {
+				l = zap.L()
+			}
+			fields := ContextFields(ctx)
+			if tr, ok := transport.FromServerContext(ctx); ok {
+				fields = append(fields, zap.String("operation", tr.Operation()))
+			}
+			return handler(WithContext(ctx, l.With(fields...)), req)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package log
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kratos/kratos/v2/metadata"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestContextLogger(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled,
+	}))
+	ctx = metadata.NewServerContext(ctx, metadata.Metadata{
+		"x-md-global-uid":      {"10001"},
+		"x-md-global-deviceid": {"d-1"},
+	})
+
+	_, err := Server(zap.New(core))(func(ctx context.Context, req interface{}) (interface{}, error) {
+		FromContext(ctx).Info("handled")
+		return nil, nil
+	})(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if fields["trace_id"] != traceID.String() || fields["uid"] != "10001" || fields["device_id"] != "d-1" {
+		t.Errorf("unexpected fields %v", fields)
+	}
+	if _, ok := fields["appid"]; ok {
+		t.Error("missing metadata should not be logged")
+	}
+}
@@ -0,0 +1,301 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	klog "github.com/go-kratos/kratos/v2/log"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// 输出目标的名称，用于单独调整日志级别
+const (
+	SinkFile    = "file"
+	SinkConsole = "console"
+	SinkLoki    = "loki"
+)
+
+// Option 日志的可选配置
+type Option func(*options)
+
+type options struct {
+	dir             string
+	name            string
+	levels          map[string]zapcore.Level
+	disabled        map[string]bool
+	fileEncoding    string
+	consoleEncoding string
+	loki            LokiConfig
+	caller          bool
+	callerSkip      int
+	stacktrace      zapcore.Level
+	replaceGlobals  bool
+	bridge          bool
+
+	filePattern  string
+	rotationTime time.Duration
+	rotationSize int64
+	maxAge       time.Duration
+	maxBackups   int
+	compress     bool
+	linkName     string
+}
+
+// WithLevel 所有输出的日志级别，默认 info
+func WithLevel(level zapcore.Level) Option {
+	return func(o *options) {
+		for _, sink := range []string{SinkFile, SinkConsole, SinkLoki} {
+			o.levels[sink] = level
+		}
+	}
+}
+
+// WithSinkLevel 单个输出的日志级别
+func WithSinkLevel(sink string, level zapcore.Level) Option {
+	return func(o *options) { o.levels[sink] = level }
+}
+
+// WithoutFile 不输出到文件
+func WithoutFile() Option {
+	return func(o *options) { o.disabled[SinkFile] = true }
+}
+
+// WithoutConsole 不输出到控制台
+func WithoutConsole() Option {
+	return func(o *options) { o.disabled[SinkConsole] = true }
+}
+
+// WithFileEncoding 文件日志的格式：json（默认）或 console
+func WithFileEncoding(encoding string) Option {
+	return func(o *options) { o.fileEncoding = encoding }
+}
+
+// WithConsoleEncoding 控制台日志的格式：json 或 console（默认）
+func WithConsoleEncoding(encoding string) Option {
+	return func(o *options) { o.consoleEncoding = encoding }
+}
+
+// WithLoki 同时发送到 loki，address 为 push 接口地址，为空时不发送
+func WithLoki(address string) Option {
+	return func(o *options) { o.loki.URL = address }
+}
+
+// WithLokiConfig 同时发送到 loki，可以配置标签、批量、重试和 spool，见 LokiConfig
+func WithLokiConfig(cfg LokiConfig) Option {
+	return func(o *options) { o.loki = cfg }
+}
+
+// WithCaller 记录调用位置，skip 为额外跳过的调用层数
+func WithCaller(skip int) Option {
+	return func(o *options) {
+		o.caller = true
+		o.callerSkip = skip
+	}
+}
+
+// WithStacktrace 该级别及以上的日志记录调用栈，默认 panic
+func WithStacktrace(level zapcore.Level) Option {
+	return func(o *options) { o.stacktrace = level }
+}
+
+// WithReplaceGlobals 替换 zap 的全局 logger，退出前调用 Close
+func WithReplaceGlobals() Option {
+	return func(o *options) { o.replaceGlobals = true }
+}
+
+// WithBridge logrus 标准 logger 和 kratos 全局 logger 也写入这个日志，见 RedirectLogrus。
+// logrus 不再输出到 stderr，级别改为 trace 由这个日志过滤，kratos 的全局 logger 被替换
+func WithBridge() Option {
+	return func(o *options) { o.bridge = true }
+}
+
+// Logger zap.Logger 以及每个输出可以动态调整的日志级别
+type Logger struct {
+	*zap.Logger
+	levels map[string]zap.AtomicLevel
+	loki   *LokiSink
+}
+
+// New 创建日志，文件默认输出到 dir 目录下的 name-%Y%m%d%H%M.log，每天切割并保存 7 天
+func New(dir string, name string, opts ...Option) (*Logger, error) {
+	o := &options{
+		dir:             dir,
+		name:            name,
+		levels:          map[string]zapcore.Level{},
+		disabled:        map[string]bool{},
+		fileEncoding:    "json",
+		consoleEncoding: "console",
+		stacktrace:      zap.PanicLevel,
+	}
+	WithLevel(zap.InfoLevel)(o)
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	// 配置 zap 编码器
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+
+	l := &Logger{levels: map[string]zap.AtomicLevel{}}
+	var cores []zapcore.Core
+
+	// 1. 文件输出核心
+	if !o.disabled[SinkFile] {
+		encoder, err := newEncoder(o.fileEncoding, encoderConfig)
+		if err != nil {
+			return nil, err
+		}
+		writer, err := newRotateWriter(o)
+		if err != nil {
+			return nil, err
+		}
+		cores = append(cores, zapcore.NewCore(encoder, zapcore.AddSync(writer), l.level(SinkFile, o)))
+	}
+
+	// 2. 控制台输出核心
+	if !o.disabled[SinkConsole] {
+		encoder, err := newEncoder(o.consoleEncoding, encoderConfig)
+		if err != nil {
+			return nil, err
+		}
+		cores = append(cores, zapcore.NewCore(encoder, zapcore.AddSync(os.Stdout), l.level(SinkConsole, o)))
+	}
+
+	// 3. Loki 输出核心 (如果配置了地址)
+	if o.loki.URL != "" {
+		sink, err := createLokiSink(o.loki, o.name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Loki sink: %w", err)
+		}
+		l.loki = sink
+		cores = append(cores, sink.Core(zapcore.NewJSONEncoder(encoderConfig), l.level(SinkLoki, o)))
+	}
+
+	zapOpts := []zap.Option{zap.AddStacktrace(o.stacktrace)}
+	if o.caller {
+		zapOpts = append(zapOpts, zap.AddCaller(), zap.AddCallerSkip(o.callerSkip))
+	}
+	l.Logger = zap.New(zapcore.NewTee(cores...), zapOpts...)
+	if o.replaceGlobals {
+		zap.ReplaceGlobals(l.Logger)
+		global.Store(l)
+	}
+	if o.bridge {
+		RedirectLogrus(l.Logger)
+		klog.SetLogger(NewKratosLogger(l.Logger))
+	}
+	return l, nil
+}
+
+// Loki 发送到 loki 的输出，没有配置时返回 nil
+func (l *Logger) Loki() *LokiSink {
+	return l.loki
+}
+
+// global 最近一次 WithReplaceGlobals 创建的日志
+var global atomic.Pointer[Logger]
+
+// Close 关闭 InitZapLogger 或 WithReplaceGlobals 创建的全局日志，程序退出前调用，
+// 否则缓冲区中还没有发送到 loki 的日志会丢失
+func Close() error {
+	if l := global.Load(); l != nil {
+		return l.Close()
+	}
+	return nil
+}
+
+// Close 刷新日志，等待发送到 loki 的日志发送完或写入 spool
+func (l *Logger) Close() error {
+	l.Sync()
+	if l.loki != nil {
+		return l.loki.Close()
+	}
+	return nil
+}
+
+func (l *Logger) level(sink string, o *options) zap.AtomicLevel {
+	level := zap.NewAtomicLevelAt(o.levels[sink])
+	l.levels[sink] = level
+	return level
+}
+
+func newEncoder(encoding string, cfg zapcore.EncoderConfig) (zapcore.Encoder, error) {
+	switch encoding {
+	case "json":
+		return zapcore.NewJSONEncoder(cfg), nil
+	case "console":
+		return zapcore.NewConsoleEncoder(cfg), nil
+	}
+	return nil, fmt.Errorf("unknown log encoding: %s", encoding)
+}
+
+// SetLevel 调整所有输出的日志级别
+func (l *Logger) SetLevel(level zapcore.Level) {
+	for _, atomic := range l.levels {
+		atomic.SetLevel(level)
+	}
+}
+
+// SetSinkLevel 调整单个输出的日志级别，输出不存在时返回 false
+func (l *Logger) SetSinkLevel(sink string, level zapcore.Level) bool {
+	atomic, ok := l.levels[sink]
+	if ok {
+		atomic.SetLevel(level)
+	}
+	return ok
+}
+
+// Levels 每个输出当前的日志级别
+func (l *Logger) Levels() map[string]string {
+	levels := make(map[string]string, len(l.levels))
+	for sink, atomic := range l.levels {
+		levels[sink] = atomic.Level().String()
+	}
+	return levels
+}
+
+// LevelHandler 查看和调整日志级别的 http 接口，挂到管理端口上，故障排查时不用重启就能打开 debug 日志。
+// GET 返回每个输出的级别；PUT {"level":"debug","sinks":["console"]} 调整级别，sinks 为空时调整所有输出
+func (l *Logger) LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var req struct {
+				Level *zapcore.Level `json:"level"`
+				Sinks []string       `json:"sinks"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if req.Level == nil {
+				http.Error(w, "level is required", http.StatusBadRequest)
+				return
+			}
+			for _, sink := range req.Sinks {
+				if _, ok := l.levels[sink]; !ok {
+					http.Error(w, "unknown sink: "+sink, http.StatusBadRequest)
+					return
+				}
+			}
+			if len(req.Sinks) == 0 {
+				l.SetLevel(*req.Level)
+			}
+			for _, sink := range req.Sinks {
+				l.SetSinkLevel(sink, *req.Level)
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(l.Levels())
+	})
+}
@@ -0,0 +1,48 @@
+package log
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestLevelHandler(t *testing.T) {
+	dir := t.TempDir()
+	l, err := New(dir, "game", WithoutConsole(), WithFileEncoding("console"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := l.LevelHandler()
+
+	l.Debug("before")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/log/level", strings.NewReader(`{"level":"debug","sinks":["file"]}`)))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"file":"debug"`) {
+		t.Fatalf("put: %d %s", rec.Code, rec.Body)
+	}
+	l.Debug("after")
+	l.Sync()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "game-*.log"))
+	if len(files) != 1 {
+		t.Fatalf("log files: %v", files)
+	}
+	data, _ := os.ReadFile(files[0])
+	if strings.Contains(string(data), "before") || !strings.Contains(string(data), "after") {
+		t.Errorf("unexpected log content: %s", data)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/log/level", strings.NewReader(`{"level":"debug","sinks":["loki"]}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown sink: %d", rec.Code)
+	}
+	if l.SetLevel(zap.WarnLevel); l.Levels()[SinkFile] != "warn" {
+		t.Errorf("levels = %v", l.Levels())
+	}
+}
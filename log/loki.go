@@ -0,0 +1,631 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// loki push 接口的编码
+const (
+	LokiProtobuf = "protobuf" // protobuf + snappy
+	LokiJSON     = "json"     // json + gzip
+)
+
+// LokiConfig loki 输出的配置，零值字段使用默认值
+type LokiConfig struct {
+	URL         string            // push 接口地址，如 http://loki:3100/loki/api/v1/push
+	TenantID    string            // 多租户时的 X-Scope-OrgID
+	Labels      map[string]string // 固定标签，如 env、zone，默认带上 app
+	EnvLabels   map[string]string // 从环境变量读取的标签，标签名 -> 环境变量名，如 {"pod": "POD_NAME"}
+	FieldLabels []string          // 从日志字段读取的标签，字段的取值应当是有限的几个，否则会拖慢 loki
+	Encoding    string            // LokiProtobuf（默认）或 LokiJSON
+
+	BufferSize int           // 内存中最多缓存的日志条数，默认 10000，满了之后丢弃新的日志
+	BatchSize  int           // 每批最多的条数，默认 1000
+	BatchWait  time.Duration // 最多等待多久发送一批，默认 1 秒
+	Timeout    time.Duration // 每次请求的超时时间，默认 10 秒
+	MinBackoff time.Duration // 第一次重试的等待时间，之后每次翻倍，默认 500ms
+	MaxBackoff time.Duration // 最长的重试等待时间，默认 30 秒
+	MaxRetries int           // 最多重试的次数，默认 5 次
+
+	SpoolDir      string // loki 不可用时保存日志的目录，恢复后补发；为空时直接丢弃
+	SpoolMaxBytes int64  // spool 目录的最大字节数，超出时删除最早的文件，默认 100MB
+}
+
+func (c *LokiConfig) setDefault() {
+	if c.Encoding == "" {
+		c.Encoding = LokiProtobuf
+	}
+	if c.BufferSize <= 0 {
+		c.BufferSize = 10000
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 1000
+	}
+	if c.BatchWait <= 0 {
+		c.BatchWait = time.Second
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 10 * time.Second
+	}
+	if c.MinBackoff <= 0 {
+		c.MinBackoff = 500 * time.Millisecond
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = 30 * time.Second
+	}
+	if c.MaxRetries <= 0 {
+		c.MaxRetries = 5
+	}
+	if c.SpoolMaxBytes <= 0 {
+		c.SpoolMaxBytes = 100 << 20
+	}
+}
+
+// LokiStats 发送情况的统计，都是日志条数
+type LokiStats struct {
+	Sent    int64 // 发送成功，包括从 spool 补发的
+	Dropped int64 // 缓冲区满、loki 拒绝或 spool 超出大小时丢弃
+	Spooled int64 // 发送失败写入 spool
+	Retries int64 // 请求重试的次数
+}
+
+type lokiEntry struct {
+	labels string
+	ts     time.Time
+	line   string
+}
+
+// spoolReplayFiles 每次最多补发的 spool 文件数，补发期间不会接收新的日志
+const spoolReplayFiles = 4
+
+// LokiSink 异步发送日志到 loki。写日志只是放入有界的缓冲区，满了直接丢弃，
+// loki 故障时不会拖慢业务；后台按批发送，失败时指数退避重试，重试耗尽后写入 spool 目录等待补发。
+// 之后的批次不再重试，直接写入 spool，按退避间隔探测一次，探测成功后恢复发送并补发 spool
+type LokiSink struct {
+	cfg         LokiConfig
+	labels      map[string]string
+	fieldLabels map[string]bool
+	client      *http.Client
+	entries     chan lokiEntry
+	syncs       chan chan struct{}
+	closing     chan struct{}
+	done        chan struct{}
+	once        sync.Once
+
+	// 只在 run 中访问
+	down         bool          // loki 不可用
+	probeAt      time.Time     // 不可用时下一次探测的时间
+	probeBackoff time.Duration // 探测间隔，每次失败翻倍
+	spoolPending bool          // spool 中可能有待补发的文件
+
+	sent    atomic.Int64
+	dropped atomic.Int64
+	spooled atomic.Int64
+	retries atomic.Int64
+}
+
+// NewLokiSink 创建 loki 输出并启动后台发送，不再使用时调用 Close
+func NewLokiSink(cfg LokiConfig) (*LokiSink, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("loki: url is required")
+	}
+	if cfg.Encoding != "" && cfg.Encoding != LokiProtobuf && cfg.Encoding != LokiJSON {
+		return nil, fmt.Errorf("loki: unknown encoding: %s", cfg.Encoding)
+	}
+	cfg.setDefault()
+	if cfg.SpoolDir != "" {
+		if err := os.MkdirAll(cfg.SpoolDir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &LokiSink{
+		cfg:         cfg,
+		labels:      maps.Clone(cfg.Labels),
+		fieldLabels: map[string]bool{},
+		client:      &http.Client{},
+		entries:     make(chan lokiEntry, cfg.BufferSize),
+		syncs:       make(chan chan struct{}),
+		closing:     make(chan struct{}),
+		done:        make(chan struct{}),
+		// 上次退出时没有补发完的文件
+		spoolPending: cfg.SpoolDir != "",
+	}
+	if s.labels == nil {
+		s.labels = map[string]string{}
+	}
+	for label, env := range cfg.EnvLabels {
+		if value := os.Getenv(env); value != "" {
+			s.labels[label] = value
+		}
+	}
+	for _, field := range cfg.FieldLabels {
+		s.fieldLabels[field] = true
+	}
+
+	go s.run()
+	return s, nil
+}
+
+// Core 写入 sink 的 zap core，日志行使用 enc 编码
+func (s *LokiSink) Core(enc zapcore.Encoder, level zapcore.LevelEnabler) zapcore.Core {
+	return newLokiCore(s, enc, level, s.labels)
+}
+
+// Stats 发送情况的统计
+func (s *LokiSink) Stats() LokiStats {
+	return LokiStats{
+		Sent:    s.sent.Load(),
+		Dropped: s.dropped.Load(),
+		Spooled: s.spooled.Load(),
+		Retries: s.retries.Load(),
+	}
+}
+
+// Sync 立即发送缓冲区中已有的日志并等待完成，只发送一次，失败时写入 spool 或丢弃
+func (s *LokiSink) Sync() error {
+	synced := make(chan struct{})
+	select {
+	case s.syncs <- synced:
+	case <-s.done:
+		return nil
+	}
+	select {
+	case <-synced:
+	case <-s.done:
+	}
+	return nil
+}
+
+// Close 停止后台发送，缓冲区中剩余的日志发送一次，失败时写入 spool
+func (s *LokiSink) Close() error {
+	s.once.Do(func() { close(s.closing) })
+	<-s.done
+	return nil
+}
+
+func (s *LokiSink) push(e lokiEntry) {
+	select {
+	case <-s.closing:
+		s.dropped.Add(1)
+		return
+	default:
+	}
+	select {
+	case s.entries <- e:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+func (s *LokiSink) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.cfg.BatchWait)
+	defer ticker.Stop()
+
+	batch := lokiBatch{}
+	// drain 读取缓冲区中已有的日志，只有这里读取 entries，len 不会变小
+	drain := func(retry bool) {
+		for len(s.entries) > 0 {
+			batch.add(<-s.entries)
+			if batch.size >= s.cfg.BatchSize {
+				s.flush(batch, retry)
+				batch = lokiBatch{}
+			}
+		}
+		s.flush(batch, retry)
+		batch = lokiBatch{}
+	}
+	for {
+		select {
+		case e := <-s.entries:
+			batch.add(e)
+			if batch.size >= s.cfg.BatchSize {
+				s.flush(batch, true)
+				batch = lokiBatch{}
+			}
+		case <-ticker.C:
+			s.flush(batch, true)
+			batch = lokiBatch{}
+			s.replaySpool()
+		case synced := <-s.syncs:
+			drain(false)
+			close(synced)
+		case <-s.closing:
+			drain(false)
+			return
+		}
+	}
+}
+
+// lokiBatch 一批日志，按标签分组
+type lokiBatch struct {
+	streams map[string][]lokiEntry
+	size    int
+}
+
+func (b *lokiBatch) add(e lokiEntry) {
+	if b.streams == nil {
+		b.streams = map[string][]lokiEntry{}
+	}
+	b.streams[e.labels] = append(b.streams[e.labels], e)
+	b.size++
+}
+
+// flush 发送一批日志，retry 为 false 时只发送一次。loki 不可用期间不到探测时间直接写入 spool
+func (s *LokiSink) flush(b lokiBatch, retry bool) {
+	if b.size == 0 {
+		return
+	}
+	n := int64(b.size)
+
+	var (
+		body []byte
+		err  error
+	)
+	if s.cfg.Encoding == LokiJSON {
+		body, err = encodeLokiJSON(b.streams)
+	} else {
+		body = encodeLokiProtobuf(b.streams)
+	}
+	if err != nil {
+		s.dropped.Add(n)
+		return
+	}
+
+	if s.down && time.Now().Before(s.probeAt) {
+		s.fail(body, s.cfg.Encoding, n)
+		return
+	}
+	if retry && !s.down {
+		err = s.send(body, s.cfg.Encoding)
+	} else {
+		err = s.post(body, s.cfg.Encoding)
+	}
+	switch {
+	case err == nil:
+		s.down = false
+		s.sent.Add(n)
+	case retryable(err):
+		s.trip()
+		s.fail(body, s.cfg.Encoding, n)
+	default:
+		s.dropped.Add(n)
+	}
+}
+
+// trip 标记 loki 不可用，探测间隔从 MinBackoff 开始每次翻倍
+func (s *LokiSink) trip() {
+	if !s.down {
+		s.down = true
+		s.probeBackoff = s.cfg.MinBackoff
+	} else {
+		s.probeBackoff = min(s.probeBackoff*2, s.cfg.MaxBackoff)
+	}
+	s.probeAt = time.Now().Add(s.probeBackoff)
+}
+
+// fail 发送失败的批次写入 spool，没有配置 spool 时丢弃
+func (s *LokiSink) fail(body []byte, encoding string, n int64) {
+	if s.cfg.SpoolDir == "" {
+		s.dropped.Add(n)
+		return
+	}
+	s.spool(body, encoding, n)
+}
+
+// send 发送失败时指数退避重试，关闭时不再等待
+func (s *LokiSink) send(body []byte, encoding string) error {
+	backoff := s.cfg.MinBackoff
+	for i := 0; ; i++ {
+		err := s.post(body, encoding)
+		if err == nil || !retryable(err) || i >= s.cfg.MaxRetries {
+			return err
+		}
+
+		s.retries.Add(1)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-s.closing:
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, s.cfg.MaxBackoff)
+	}
+}
+
+func (s *LokiSink) post(body []byte, encoding string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if encoding == LokiJSON {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", "gzip")
+	} else {
+		req.Header.Set("Content-Type", "application/x-protobuf")
+	}
+	if s.cfg.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", s.cfg.TenantID)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return &lokiStatusError{code: resp.StatusCode, msg: strings.TrimSpace(string(msg))}
+}
+
+type lokiStatusError struct {
+	code int
+	msg  string
+}
+
+func (e *lokiStatusError) Error() string {
+	return fmt.Sprintf("loki: push failed with status %d: %s", e.code, e.msg)
+}
+
+// retryable 网络错误、429 和 5xx 可以重试，其他 4xx 说明日志本身有问题，重试也不会成功
+func retryable(err error) bool {
+	var statusErr *lokiStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code == http.StatusTooManyRequests || statusErr.code >= 500
+	}
+	return true
+}
+
+// spool 文件名为 时间戳-条数.编码，按文件名排序即为写入顺序
+type spoolFile struct {
+	path     string
+	count    int64
+	encoding string
+	size     int64
+}
+
+func (s *LokiSink) spool(body []byte, encoding string, n int64) {
+	name := filepath.Join(s.cfg.SpoolDir, fmt.Sprintf("%020d-%d.%s", time.Now().UnixNano(), n, encoding))
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, body, 0644); err != nil {
+		s.dropped.Add(n)
+		return
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		s.dropped.Add(n)
+		return
+	}
+	s.spooled.Add(n)
+	s.spoolPending = true
+
+	// 超出大小时删除最早的文件
+	files := s.spoolFiles()
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	for _, f := range files {
+		if total <= s.cfg.SpoolMaxBytes {
+			break
+		}
+		if os.Remove(f.path) == nil {
+			total -= f.size
+			s.dropped.Add(f.count)
+		}
+	}
+}
+
+// replaySpool 按顺序补发 spool 中最早的几个文件，遇到可以重试的错误时停止；
+// loki 不可用期间只在探测时间补发，作为恢复的探测
+func (s *LokiSink) replaySpool() {
+	if !s.spoolPending || (s.down && time.Now().Before(s.probeAt)) {
+		return
+	}
+	files := s.spoolFiles()
+	if len(files) == 0 {
+		s.spoolPending = false
+		return
+	}
+
+	for _, f := range files[:min(len(files), spoolReplayFiles)] {
+		select {
+		case <-s.closing:
+			return
+		default:
+		}
+
+		body, err := os.ReadFile(f.path)
+		if err != nil {
+			continue
+		}
+		err = s.post(body, f.encoding)
+		if err != nil && retryable(err) {
+			s.trip()
+			return
+		}
+		s.down = false
+		os.Remove(f.path)
+		if err != nil {
+			s.dropped.Add(f.count)
+		} else {
+			s.sent.Add(f.count)
+		}
+	}
+}
+
+func (s *LokiSink) spoolFiles() []spoolFile {
+	entries, err := os.ReadDir(s.cfg.SpoolDir)
+	if err != nil {
+		return nil
+	}
+	var files []spoolFile
+	for _, entry := range entries {
+		name, encoding, ok := strings.Cut(entry.Name(), ".")
+		if !ok || (encoding != LokiProtobuf && encoding != LokiJSON) {
+			continue
+		}
+		_, count, ok := strings.Cut(name, "-")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(count, 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, spoolFile{
+			path:     filepath.Join(s.cfg.SpoolDir, entry.Name()),
+			count:    n,
+			encoding: encoding,
+			size:     info.Size(),
+		})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	return files
+}
+
+// lokiCore 把日志编码后放入 LokiSink，标签由固定标签、日志级别和 FieldLabels 中的字段组成
+type lokiCore struct {
+	sink        *LokiSink
+	enc         zapcore.Encoder
+	level       zapcore.LevelEnabler
+	labels      map[string]string
+	levelLabels [zapcore.FatalLevel - zapcore.DebugLevel + 1]string // 每个级别格式化好的标签
+}
+
+func newLokiCore(sink *LokiSink, enc zapcore.Encoder, level zapcore.LevelEnabler, labels map[string]string) *lokiCore {
+	c := &lokiCore{sink: sink, enc: enc, level: level, labels: labels}
+	for l := zapcore.DebugLevel; l <= zapcore.FatalLevel; l++ {
+		c.levelLabels[l-zapcore.DebugLevel] = formatLokiLabels(labels, l)
+	}
+	return c
+}
+
+func (c *lokiCore) Enabled(level zapcore.Level) bool {
+	return c.level.Enabled(level)
+}
+
+func (c *lokiCore) With(fields []zapcore.Field) zapcore.Core {
+	labels, _ := c.withFieldLabels(fields)
+	clone := newLokiCore(c.sink, c.enc.Clone(), c.level, labels)
+	for i := range fields {
+		fields[i].AddTo(clone.enc)
+	}
+	return clone
+}
+
+func (c *lokiCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *lokiCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	line := strings.TrimSuffix(buf.String(), "\n")
+	buf.Free()
+
+	labels := c.levelLabels[ent.Level-zapcore.DebugLevel]
+	if merged, ok := c.withFieldLabels(fields); ok {
+		labels = formatLokiLabels(merged, ent.Level)
+	}
+	c.sink.push(lokiEntry{labels: labels, ts: ent.Time, line: line})
+	if ent.Level > zapcore.ErrorLevel {
+		// panic 和 fatal 之后进程可能退出，等待发送完成
+		return c.sink.Sync()
+	}
+	return nil
+}
+
+func (c *lokiCore) Sync() error {
+	return c.sink.Sync()
+}
+
+// withFieldLabels 把 FieldLabels 中的字段加入标签，没有这类字段时返回 c.labels 本身和 false
+func (c *lokiCore) withFieldLabels(fields []zapcore.Field) (map[string]string, bool) {
+	var merged map[string]string
+	for _, f := range fields {
+		if !c.sink.fieldLabels[f.Key] {
+			continue
+		}
+		if merged == nil {
+			merged = maps.Clone(c.labels)
+		}
+		merged[f.Key] = fieldString(f)
+	}
+	if merged == nil {
+		return c.labels, false
+	}
+	return merged, true
+}
+
+func fieldString(f zapcore.Field) string {
+	if f.Type == zapcore.StringType {
+		return f.String
+	}
+	enc := zapcore.NewMapObjectEncoder()
+	f.AddTo(enc)
+	return fmt.Sprint(enc.Fields[f.Key])
+}
+
+// formatLokiLabels 按 loki 的格式 {a="1", b="2"} 输出，key 排序后相同的标签得到相同的 stream
+func formatLokiLabels(labels map[string]string, level zapcore.Level) string {
+	keys := make([]string, 0, len(labels)+1)
+	for key := range labels {
+		if key != "level" {
+			keys = append(keys, key)
+		}
+	}
+	keys = append(keys, "level")
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		value := level.String()
+		if key != "level" {
+			value = labels[key]
+		}
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(value))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
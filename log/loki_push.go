@@ -0,0 +1,109 @@
+package log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// loki 的 push 请求，字段见 https://github.com/grafana/loki/blob/main/pkg/push/push.proto
+//
+//	PushRequest { repeated StreamAdapter streams = 1; }
+//	StreamAdapter { string labels = 1; repeated EntryAdapter entries = 2; }
+//	EntryAdapter { google.protobuf.Timestamp timestamp = 1; string line = 2; }
+//
+// 只有这几个字段，直接用 protowire 编码，不引入 loki 的依赖
+func encodeLokiProtobuf(streams map[string][]lokiEntry) []byte {
+	var req []byte
+	for _, labels := range sortedLabels(streams) {
+		var stream []byte
+		stream = protowire.AppendTag(stream, 1, protowire.BytesType)
+		stream = protowire.AppendString(stream, labels)
+		for _, e := range streams[labels] {
+			var ts []byte
+			ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(e.ts.Unix()))
+			ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(e.ts.Nanosecond()))
+
+			var entry []byte
+			entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+			entry = protowire.AppendBytes(entry, ts)
+			entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+			entry = protowire.AppendString(entry, e.line)
+
+			stream = protowire.AppendTag(stream, 2, protowire.BytesType)
+			stream = protowire.AppendBytes(stream, entry)
+		}
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, stream)
+	}
+	return snappy.Encode(nil, req)
+}
+
+type lokiJSONStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// encodeLokiJSON {"streams":[{"stream":{"app":"x"},"values":[["纳秒时间戳","日志"]]}]}，gzip 压缩
+func encodeLokiJSON(streams map[string][]lokiEntry) ([]byte, error) {
+	req := struct {
+		Streams []lokiJSONStream `json:"streams"`
+	}{}
+	for _, labels := range sortedLabels(streams) {
+		stream := lokiJSONStream{Stream: parseLokiLabels(labels)}
+		for _, e := range streams[labels] {
+			stream.Values = append(stream.Values, [2]string{strconv.FormatInt(e.ts.UnixNano(), 10), e.line})
+		}
+		req.Streams = append(req.Streams, stream)
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(req); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func sortedLabels(streams map[string][]lokiEntry) []string {
+	labels := make([]string, 0, len(streams))
+	for key := range streams {
+		labels = append(labels, key)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// parseLokiLabels 解析 formatLokiLabels 的输出
+func parseLokiLabels(s string) map[string]string {
+	labels := map[string]string{}
+	s = s[1 : len(s)-1]
+	for s != "" {
+		i := strings.IndexByte(s, '=')
+		if i < 0 {
+			break
+		}
+		key := s[:i]
+		value, err := strconv.QuotedPrefix(s[i+1:])
+		if err != nil {
+			break
+		}
+		labels[key], _ = strconv.Unquote(value)
+		s = s[i+1+len(value):]
+		if len(s) >= 2 && s[:2] == ", " {
+			s = s[2:]
+		}
+	}
+	return labels
+}
@@ -0,0 +1,386 @@
+package log
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// lokiStub 模拟 loki 的 push 接口，down 时返回 503
+type lokiStub struct {
+	*httptest.Server
+	down     atomic.Bool
+	attempts atomic.Int64
+
+	mu     sync.Mutex
+	bodies [][]byte
+}
+
+func newLokiStub(t *testing.T) *lokiStub {
+	stub := &lokiStub{}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stub.attempts.Add(1)
+		if stub.down.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			body = zr
+		}
+		data, _ := io.ReadAll(body)
+		if r.Header.Get("Content-Type") == "application/x-protobuf" {
+			var err error
+			if data, err = snappy.Decode(nil, data); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		stub.mu.Lock()
+		stub.bodies = append(stub.bodies, data)
+		stub.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+func (s *lokiStub) received() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte(nil), s.bodies...)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLokiSinkJSON(t *testing.T) {
+	stub := newLokiStub(t)
+	t.Setenv("TEST_POD_NAME", "game-0")
+	sink, err := NewLokiSink(LokiConfig{
+		URL:         stub.URL,
+		Labels:      map[string]string{"app": "game", "env": "test"},
+		EnvLabels:   map[string]string{"pod": "TEST_POD_NAME"},
+		FieldLabels: []string{"zone"},
+		Encoding:    LokiJSON,
+		BatchSize:   2,
+		BatchWait:   time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	logger := zap.New(sink.Core(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zap.InfoLevel))
+	logger.With(zap.Int("zone", 3)).Info("login", zap.Uint64("uid", 10001))
+	logger.Warn("slow")
+	waitFor(t, func() bool { return sink.Stats().Sent == 2 })
+
+	bodies := stub.received()
+	if len(bodies) != 1 {
+		t.Fatalf("got %d requests", len(bodies))
+	}
+	var req struct {
+		Streams []lokiJSONStream `json:"streams"`
+	}
+	if err := json.Unmarshal(bodies[0], &req); err != nil {
+		t.Fatal(err)
+	}
+	if len(req.Streams) != 2 {
+		t.Fatalf("streams: %+v", req.Streams)
+	}
+	for _, stream := range req.Streams {
+		labels := stream.Stream
+		if labels["app"] != "game" || labels["env"] != "test" || labels["pod"] != "game-0" {
+			t.Errorf("labels: %v", labels)
+		}
+		line := stream.Values[0][1]
+		switch labels["level"] {
+		case "info":
+			if labels["zone"] != "3" || !strings.Contains(line, `"uid":10001`) {
+				t.Errorf("info stream: %v %s", labels, line)
+			}
+		case "warn":
+			if _, ok := labels["zone"]; ok || !strings.Contains(line, `"msg":"slow"`) {
+				t.Errorf("warn stream: %v %s", labels, line)
+			}
+		default:
+			t.Errorf("labels: %v", labels)
+		}
+	}
+}
+
+// consumeMessage 按字段号遍历一层 protobuf 消息，bytes 字段传原始内容，varint 字段传数值
+func consumeMessage(t *testing.T, b []byte, field func(num protowire.Number, v []byte, n uint64)) {
+	t.Helper()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			field(num, v, 0)
+			b = b[n:]
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			field(num, nil, v)
+			b = b[n:]
+		default:
+			t.Fatalf("field %d: unexpected wire type %d", num, typ)
+		}
+	}
+}
+
+func TestEncodeLokiProtobuf(t *testing.T) {
+	t1 := time.Unix(1700000000, 123456789)
+	t2 := time.Unix(1700000001, 0)
+	streams := map[string][]lokiEntry{
+		`{app="game", level="warn"}`: {{ts: t2, line: "slow"}},
+		`{app="game", level="info"}`: {{ts: t1, line: "login"}, {ts: t2, line: "logout"}},
+	}
+	data, err := snappy.Decode(nil, encodeLokiProtobuf(streams))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 按 PushRequest 解析回来，和输入逐字段比较
+	type entry struct {
+		sec, nanos uint64
+		line       string
+	}
+	type stream struct {
+		labels  string
+		entries []entry
+	}
+	var got []stream
+	consumeMessage(t, data, func(num protowire.Number, v []byte, _ uint64) {
+		if num != 1 {
+			t.Fatalf("PushRequest: unexpected field %d", num)
+		}
+		var s stream
+		consumeMessage(t, v, func(num protowire.Number, v []byte, _ uint64) {
+			switch num {
+			case 1:
+				s.labels = string(v)
+			case 2:
+				var e entry
+				consumeMessage(t, v, func(num protowire.Number, v []byte, _ uint64) {
+					switch num {
+					case 1:
+						consumeMessage(t, v, func(num protowire.Number, _ []byte, n uint64) {
+							switch num {
+							case 1:
+								e.sec = n
+							case 2:
+								e.nanos = n
+							}
+						})
+					case 2:
+						e.line = string(v)
+					}
+				})
+				s.entries = append(s.entries, e)
+			}
+		})
+		got = append(got, s)
+	})
+
+	want := []stream{
+		{`{app="game", level="info"}`, []entry{{1700000000, 123456789, "login"}, {1700000001, 0, "logout"}}},
+		{`{app="game", level="warn"}`, []entry{{1700000001, 0, "slow"}}},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestLokiSinkSpool(t *testing.T) {
+	stub := newLokiStub(t)
+	stub.down.Store(true)
+	sink, err := NewLokiSink(LokiConfig{
+		URL:        stub.URL,
+		Labels:     map[string]string{"app": "game"},
+		BatchWait:  20 * time.Millisecond,
+		MinBackoff: time.Millisecond,
+		MaxRetries: 2,
+		SpoolDir:   t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	logger := zap.New(sink.Core(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zap.InfoLevel))
+	logger.Info("during outage")
+	waitFor(t, func() bool { return sink.Stats().Spooled == 1 })
+	if stats := sink.Stats(); stats.Retries != 2 || stats.Sent != 0 {
+		t.Errorf("stats: %+v", stats)
+	}
+
+	// 恢复后空闲时补发
+	stub.down.Store(false)
+	waitFor(t, func() bool { return sink.Stats().Sent == 1 })
+	bodies := stub.received()
+	if len(bodies) != 1 || !strings.Contains(string(bodies[0]), "during outage") || !strings.Contains(string(bodies[0]), `{app="game", level="info"}`) {
+		t.Errorf("bodies: %q", bodies)
+	}
+	if files := sink.spoolFiles(); len(files) != 0 {
+		t.Errorf("spool files left: %v", files)
+	}
+}
+
+func TestLokiSinkBackpressure(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+
+	sink, err := NewLokiSink(LokiConfig{URL: server.URL, BufferSize: 2, BatchSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := zap.New(sink.Core(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zap.InfoLevel))
+
+	// 第一条阻塞在发送中，缓冲区放下两条，其余丢弃且不阻塞
+	begin := time.Now()
+	for i := 0; i < 100; i++ {
+		logger.Info("flood")
+	}
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Errorf("logging blocked for %v", elapsed)
+	}
+	if dropped := sink.Stats().Dropped; dropped < 97 {
+		t.Errorf("dropped = %d", dropped)
+	}
+
+	close(release)
+	sink.Close()
+	if stats := sink.Stats(); stats.Sent+stats.Dropped != 100 {
+		t.Errorf("stats: %+v", stats)
+	}
+}
+
+func TestLokiSinkCircuitBreaker(t *testing.T) {
+	stub := newLokiStub(t)
+	stub.down.Store(true)
+	sink, err := NewLokiSink(LokiConfig{
+		URL:        stub.URL,
+		BatchSize:  1,
+		BatchWait:  20 * time.Millisecond,
+		MinBackoff: 100 * time.Millisecond,
+		MaxRetries: 1,
+		SpoolDir:   t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	logger := zap.New(sink.Core(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zap.InfoLevel))
+
+	// 第一批重试后写入 spool，之后的批次不再请求 loki
+	logger.Info("first")
+	waitFor(t, func() bool { return sink.Stats().Spooled == 1 })
+	logger.Info("second")
+	logger.Info("third")
+	waitFor(t, func() bool { return sink.Stats().Spooled == 3 })
+	if attempts := stub.attempts.Load(); attempts != 2 {
+		t.Errorf("attempts = %d, want 2", attempts)
+	}
+
+	// 探测成功后补发所有 spool
+	stub.down.Store(false)
+	waitFor(t, func() bool { return sink.Stats().Sent == 3 })
+	if stats := sink.Stats(); stats.Retries != 1 || stats.Dropped != 0 {
+		t.Errorf("stats: %+v", stats)
+	}
+}
+
+func TestLokiSinkReplayLimit(t *testing.T) {
+	stub := newLokiStub(t)
+	dir := t.TempDir()
+	body := encodeLokiProtobuf(map[string][]lokiEntry{`{level="info"}`: {{labels: `{level="info"}`, ts: time.Now(), line: "old"}}})
+	for i := 0; i < 10; i++ {
+		name := filepath.Join(dir, fmt.Sprintf("%020d-1.%s", i, LokiProtobuf))
+		if err := os.WriteFile(name, body, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink, err := NewLokiSink(LokiConfig{URL: stub.URL, BatchWait: time.Hour, SpoolDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	// 上次退出时留下的文件每次最多补发 spoolReplayFiles 个
+	for _, want := range []int{4, 8, 10, 10} {
+		sink.replaySpool()
+		if got := len(stub.received()); got != want {
+			t.Fatalf("received %d, want %d", got, want)
+		}
+	}
+	if files := sink.spoolFiles(); len(files) != 0 || sink.Stats().Sent != 10 {
+		t.Errorf("files %v, stats %+v", files, sink.Stats())
+	}
+}
+
+func TestLokiSinkSync(t *testing.T) {
+	stub := newLokiStub(t)
+	l, err := New(t.TempDir(), "game", WithoutFile(), WithoutConsole(), WithReplaceGlobals(),
+		WithLokiConfig(LokiConfig{URL: stub.URL, BatchWait: time.Hour}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zap.ReplaceGlobals(zap.NewNop())
+
+	// panic、fatal 级别写入后立即发送
+	l.DPanic("crash")
+	if got := stub.received(); len(got) != 1 || !strings.Contains(string(got[0]), "crash") {
+		t.Fatalf("received %q", got)
+	}
+
+	l.Info("shutdown")
+	if err := Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := stub.received(); len(got) != 2 || !strings.Contains(string(got[1]), "shutdown") {
+		t.Errorf("received %q", got)
+	}
+}
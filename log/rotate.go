@@ -0,0 +1,243 @@
+package log
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
+)
+
+const (
+	defaultRotationTime = 24 * time.Hour
+	defaultMaxAge       = 7 * 24 * time.Hour
+)
+
+// WithFilePattern 日志文件名格式（strftime），相对于 dir，默认 name-%Y%m%d%H%M.log
+func WithFilePattern(pattern string) Option {
+	return func(o *options) { o.filePattern = pattern }
+}
+
+// WithRotationTime 按时间切割的间隔，默认 24 小时
+func WithRotationTime(d time.Duration) Option {
+	return func(o *options) { o.rotationTime = d }
+}
+
+// WithRotationSize 单个文件超过 size 字节后切割，新文件名加上 .1 .2 等后缀
+func WithRotationSize(size int64) Option {
+	return func(o *options) { o.rotationSize = size }
+}
+
+// WithMaxAge 文件最长保存时间，默认 7 天，小于 0 不按时间清理
+func WithMaxAge(d time.Duration) Option {
+	return func(o *options) { o.maxAge = d }
+}
+
+// WithMaxBackups 最多保留的历史文件数（不含当前文件），默认不限制
+func WithMaxBackups(n int) Option {
+	return func(o *options) { o.maxBackups = n }
+}
+
+// WithCompress 切割后用 gzip 压缩历史文件
+func WithCompress() Option {
+	return func(o *options) { o.compress = true }
+}
+
+// WithLinkName 创建指向当前日志文件的软链接，相对于 dir
+func WithLinkName(name string) Option {
+	return func(o *options) { o.linkName = name }
+}
+
+// rotator 处理切割后的压缩和清理，rotatelogs 自带的清理不包含 .1 后缀和压缩后的文件，
+// 而且按 name-*.log 匹配，同一目录下 battle 的清理会删掉 battle-admin 的文件，所以全部由 rotator 负责
+type rotator struct {
+	mu         sync.Mutex
+	writer     *rotatelogs.RotateLogs
+	glob       string
+	files      *regexp.Regexp // 按 pattern 中各字段的宽度匹配，排除 glob 误匹配到的其它日志
+	maxAge     time.Duration
+	maxBackups int
+	compress   bool
+}
+
+var patternConversion = regexp.MustCompile(`%[%+A-Za-z]`)
+
+// conversionRegexps strftime 字段对应的正则，未列出的字段按任意非分隔符匹配
+var conversionRegexps = map[string]string{
+	"%Y": `\d{4}`, "%C": `\d{2}`, "%y": `\d{2}`, "%m": `\d{2}`, "%d": `\d{2}`, "%e": `[ \d]\d`,
+	"%H": `\d{2}`, "%I": `\d{2}`, "%M": `\d{2}`, "%S": `\d{2}`, "%j": `\d{3}`,
+	"%U": `\d{2}`, "%V": `\d{2}`, "%W": `\d{2}`, "%u": `\d`, "%w": `\d`,
+	"%F": `\d{4}-\d{2}-\d{2}`, "%T": `\d{2}:\d{2}:\d{2}`, "%R": `\d{2}:\d{2}`, "%D": `\d{2}/\d{2}/\d{2}`,
+	"%%": `%`,
+}
+
+// patternRegexp 把 strftime 格式转成匹配历史文件的正则，允许 .1 后缀和 .gz
+func patternRegexp(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	last := 0
+	for _, loc := range patternConversion.FindAllStringIndex(pattern, -1) {
+		b.WriteString(regexp.QuoteMeta(pattern[last:loc[0]]))
+		if expr, ok := conversionRegexps[pattern[loc[0]:loc[1]]]; ok {
+			b.WriteString(expr)
+		} else {
+			b.WriteString(`[^/\\]+?`)
+		}
+		last = loc[1]
+	}
+	b.WriteString(regexp.QuoteMeta(pattern[last:]))
+	b.WriteString(`(\.\d+)?(\.gz)?$`)
+	return regexp.MustCompile(b.String())
+}
+
+func newRotateWriter(o *options) (writer *rotatelogs.RotateLogs, err error) {
+	pattern := o.filePattern
+	if pattern == "" {
+		pattern = o.name + "-%Y%m%d%H%M.log"
+	}
+	pattern = filepath.Join(o.dir, pattern)
+
+	rotationTime := o.rotationTime
+	if rotationTime <= 0 {
+		rotationTime = defaultRotationTime
+	}
+	maxAge := o.maxAge
+	if maxAge == 0 {
+		maxAge = defaultMaxAge
+	}
+	r := &rotator{
+		// 匹配切割出的 .1 后缀和 .gz 文件
+		glob:       patternConversion.ReplaceAllString(pattern, "*") + "*",
+		files:      patternRegexp(pattern),
+		maxAge:     maxAge,
+		maxBackups: o.maxBackups,
+		compress:   o.compress,
+	}
+
+	opts := []rotatelogs.Option{
+		rotatelogs.WithRotationTime(rotationTime), // 日志切割时间间隔
+		rotatelogs.WithHandler(rotatelogs.HandlerFunc(r.handle)),
+		// rotatelogs 不能关闭清理，保留数量设为最大，按时间和数量清理都交给 rotator
+		rotatelogs.WithRotationCount(^uint(0)),
+	}
+	if o.rotationSize > 0 {
+		opts = append(opts, rotatelogs.WithRotationSize(o.rotationSize))
+	}
+	if o.linkName != "" {
+		opts = append(opts, rotatelogs.WithLinkName(filepath.Join(o.dir, o.linkName)))
+	}
+	r.writer, err = rotatelogs.New(pattern, opts...)
+	return r.writer, err
+}
+
+func (r *rotator) handle(e rotatelogs.Event) {
+	rotated, ok := e.(*rotatelogs.FileRotatedEvent)
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// 事件在各自的 goroutine 中处理，顺序不确定，前一个文件可能已经被清理
+	if prev := rotated.PreviousFile(); prev != "" && r.compress {
+		if err := gzipFile(prev); err != nil && !os.IsNotExist(err) {
+			os.Stderr.WriteString("compress log " + prev + ": " + err.Error() + "\n")
+		}
+	}
+	r.cleanup(r.writer.CurrentFileName())
+}
+
+// cleanup 删除超过保存时间和超出数量的历史文件
+func (r *rotator) cleanup(current string) {
+	matches, err := filepath.Glob(r.glob)
+	if err != nil {
+		return
+	}
+
+	type backup struct {
+		path       string
+		modTime    time.Time
+		generation int
+	}
+	var backups []backup
+	for _, path := range matches {
+		if path == current || !r.files.MatchString(path) || strings.HasSuffix(path, "_lock") || strings.HasSuffix(path, "_symlink") || strings.HasSuffix(path, ".tmp") {
+			continue
+		}
+		fi, err := os.Lstat(path)
+		if err != nil || fi.Mode()&os.ModeSymlink != 0 {
+			continue
+		}
+		backups = append(backups, backup{path, fi.ModTime(), generation(path)})
+	}
+	// 按大小切割的文件修改时间可能相同，再按后缀的序号排序
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].modTime.Equal(backups[j].modTime) {
+			return backups[i].modTime.After(backups[j].modTime)
+		}
+		return backups[i].generation > backups[j].generation
+	})
+
+	cutoff := time.Now().Add(-r.maxAge)
+	for i, b := range backups {
+		if (r.maxBackups > 0 && i >= r.maxBackups) || (r.maxAge > 0 && b.modTime.Before(cutoff)) {
+			os.Remove(b.path)
+		}
+	}
+}
+
+// generation 按大小切割时文件名的 .1 .2 后缀
+func generation(path string) int {
+	path = strings.TrimSuffix(path, ".gz")
+	n, err := strconv.Atoi(strings.TrimPrefix(filepath.Ext(path), "."))
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// gzipFile 压缩为 path.gz 并删除原文件，保留原文件的修改时间用于清理
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	zw.Name = filepath.Base(path)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	os.Chtimes(tmp, fi.ModTime(), fi.ModTime())
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,112 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotateBySize(t *testing.T) {
+	dir := t.TempDir()
+	l, err := New(dir, "battle", WithoutConsole(), WithRotationSize(200), WithMaxBackups(2), WithCompress(), WithLinkName("battle.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		l.Info(strings.Repeat("x", 100))
+	}
+
+	// 压缩和清理是异步的
+	var gz []string
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		gz, _ = filepath.Glob(filepath.Join(dir, "battle-*.log*.gz"))
+		if len(gz) == 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if len(gz) != 2 {
+		t.Fatalf("compressed backups: %v", gz)
+	}
+	logs, _ := filepath.Glob(filepath.Join(dir, "battle-*.log*"))
+	if len(logs) != 3 {
+		t.Errorf("files: %v", logs)
+	}
+
+	link, err := os.Readlink(filepath.Join(dir, "battle.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.HasSuffix(link, ".gz") {
+		t.Errorf("link should point to current file: %s", link)
+	}
+}
+
+func TestPatternRegexp(t *testing.T) {
+	re := patternRegexp(filepath.Join("logs", "battle-%Y%m%d%H%M.log"))
+	cases := map[string]bool{
+		filepath.Join("logs", "battle-202610181030.log"):         true,
+		filepath.Join("logs", "battle-202610181030.log.2"):       true,
+		filepath.Join("logs", "battle-202610181030.log.2.gz"):    true,
+		filepath.Join("logs", "battle-admin-202610181030.log"):   false,
+		filepath.Join("logs", "battle-2026101810301.log"):        false,
+		filepath.Join("logs", "battle-202610181030.log_symlink"): false,
+	}
+	for path, want := range cases {
+		if got := re.MatchString(path); got != want {
+			t.Errorf("match %s = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestRotateSharedDir(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-48 * time.Hour)
+	for _, name := range []string{"battle-202001010000.log", "battle-admin-202001010000.log"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, old, old)
+	}
+
+	admin, err := New(dir, "battle-admin", WithoutConsole(), WithRotationSize(200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		admin.Info(strings.Repeat("x", 100))
+	}
+	adminFiles, _ := filepath.Glob(filepath.Join(dir, "battle-admin-*.log*"))
+
+	// battle 按时间和数量清理时只删除自己的文件
+	battle, err := New(dir, "battle", WithoutConsole(), WithRotationSize(200), WithMaxBackups(1), WithMaxAge(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		battle.Info(strings.Repeat("x", 100))
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "battle-202001010000.log")); os.IsNotExist(err) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "battle-202001010000.log")); !os.IsNotExist(err) {
+		t.Error("expired battle log should be removed")
+	}
+	for _, path := range adminFiles {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("battle-admin file removed: %v", err)
+		}
+	}
+	if len(adminFiles) < 3 {
+		t.Errorf("battle-admin files: %v", adminFiles)
+	}
+}
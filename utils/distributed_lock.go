@@ -1,13 +1,37 @@
 package utils
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
 	"github.com/go-redsync/redsync/v4"
+	redsyncredis "github.com/go-redsync/redsync/v4/redis"
 	"github.com/go-redsync/redsync/v4/redis/goredis/v9"
 	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrFailed      = errors.New("lock: failed to acquire lock")      // 加锁失败（重试耗尽、redis 出错或 ctx 结束）
+	ErrTaken       = errors.New("lock: already taken")               // 锁被其他持有者占用
+	ErrNotHeld     = errors.New("lock: not held or already expired") // 解锁、续期时锁已经过期或被其他人持有
+	ErrUnsupported = errors.New("lock: not supported by redlock")    // fencing token、信号量和读写锁只能在单个 redis 上实现
+)
+
+const (
+	defaultLockExpiry = 8 * time.Second
+	defaultLockTries  = 32
+	minLockRetryDelay = 50 * time.Millisecond
+	maxLockRetryDelay = 250 * time.Millisecond
 )
 
 type DistributedLock struct {
-	rs *redsync.Redsync //分布式锁
+	rs     *redsync.Redsync //分布式锁
+	client redis.Cmdable    // fencing token、信号量和读写锁，Redlock 时为 nil
 }
 
 func NewDistributedLock(redisClient *redis.Client) *DistributedLock {
@@ -18,7 +42,315 @@ func NewDistributedLock(redisClient *redis.Client) *DistributedLock {
 	// 创建redsync实例
 	rs := redsync.New(pool)
 
-	return &DistributedLock{rs: rs}
+	return &DistributedLock{rs: rs, client: redisClient}
+}
+
+// NewRedlock 使用多个相互独立的 redis 节点创建 Redlock，超过半数节点加锁成功才算成功。
+// 单个节点上的计数和脚本没有多数派的保证，WithLock、NewSemaphore 和 NewRWLock 返回 ErrUnsupported
+func NewRedlock(clients ...redis.UniversalClient) (*DistributedLock, error) {
+	if len(clients) == 0 {
+		return nil, errors.New("lock: redlock needs at least one redis client")
+	}
+	pools := make([]redsyncredis.Pool, len(clients))
+	for i, client := range clients {
+		pools[i] = goredis.NewPool(client)
+	}
+	return &DistributedLock{rs: redsync.New(pools...)}, nil
+}
+
+// LockOption 加锁选项
+type LockOption func(*lockOptions)
+
+type lockOptions struct {
+	expiry     time.Duration
+	tries      int
+	retryDelay time.Duration
+	watchdog   bool
+	fencingKey string
+}
+
+// WithExpiry 锁的过期时间，默认 8 秒，开启看门狗时每 expiry/3 续期一次
+func WithExpiry(expiry time.Duration) LockOption {
+	return func(o *lockOptions) { o.expiry = expiry }
+}
+
+// WithTries 最多尝试加锁的次数，默认 32 次
+func WithTries(tries int) LockOption {
+	return func(o *lockOptions) { o.tries = tries }
+}
+
+// WithRetryDelay 每次重试的间隔，默认 50~250ms 随机
+func WithRetryDelay(delay time.Duration) LockOption {
+	return func(o *lockOptions) { o.retryDelay = delay }
+}
+
+// WithoutWatchdog 不自动续期，锁在 expiry 后自动释放
+func WithoutWatchdog() LockOption {
+	return func(o *lockOptions) { o.watchdog = false }
+}
+
+// WithFencingKey WithLock 发放 fencing token 使用的计数器 key，默认为 <key>:fencing。
+// 同一类资源共用一个计数器时 token 仍然单调递增，例如订单锁都使用 fencing:order，避免每个 key 留下一个计数器
+func WithFencingKey(fencingKey string) LockOption {
+	return func(o *lockOptions) { o.fencingKey = fencingKey }
+}
+
+func newLockOptions(opts []LockOption) lockOptions {
+	o := lockOptions{expiry: defaultLockExpiry, tries: defaultLockTries, watchdog: true}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// delay 重试间隔，默认与 redsync 一致取 50~250ms 随机值
+func (o lockOptions) delay() time.Duration {
+	if o.retryDelay > 0 {
+		return o.retryDelay
+	}
+	return minLockRetryDelay + rand.N(maxLockRetryDelay-minLockRetryDelay)
+}
+
+func (sc *DistributedLock) newMutex(key string, opts []LockOption) (*redsync.Mutex, lockOptions) {
+	o := newLockOptions(opts)
+	mutexOpts := []redsync.Option{
+		redsync.WithExpiry(o.expiry),
+		redsync.WithRetryDelayFunc(func(tries int) time.Duration {
+			// 每次重试说明上一次没有拿到锁
+			getLockMetrics().contended(key)
+			return o.delay()
+		}),
+	}
+	if o.tries > 0 {
+		mutexOpts = append(mutexOpts, redsync.WithTries(o.tries))
+	}
+	return sc.rs.NewMutex(key, mutexOpts...), o
+}
+
+// LockContext 加锁，锁被占用时按选项重试直到成功、重试耗尽或 ctx 结束，
+// 成功后返回的 LockHandle 必须调用 Unlock 释放
+func (sc *DistributedLock) LockContext(ctx context.Context, key string, opts ...LockOption) (*LockHandle, error) {
+	mutex, o := sc.newMutex(key, opts)
+	begin := time.Now()
+	err := mutex.LockContext(ctx)
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		err = fmt.Errorf("%w: %w", ErrFailed, ctx.Err())
+	default:
+		err = lockError(err)
+	}
+	getLockMetrics().acquired(key, begin, err)
+	if err != nil {
+		return nil, err
+	}
+	return newLockHandle(mutex, o), nil
+}
+
+// TryLock 只尝试一次，锁被占用时返回 ErrTaken
+func (sc *DistributedLock) TryLock(ctx context.Context, key string, opts ...LockOption) (*LockHandle, error) {
+	mutex, o := sc.newMutex(key, opts)
+	begin := time.Now()
+	err := mutex.TryLockContext(ctx)
+	if err != nil {
+		err = lockError(err)
+	}
+	getLockMetrics().acquired(key, begin, err)
+	if err != nil {
+		return nil, err
+	}
+	return newLockHandle(mutex, o), nil
+}
+
+// WithLock 加锁后执行 fn，无论 fn 是否出错或 panic 都会释放锁。
+// token 是每个 key 单调递增的 fencing token，写库时带上 token 并拒绝比已写入的 token 小的请求，
+// 可以防止锁过期后旧的持有者继续写入，加锁后锁已经过期时返回 ErrNotHeld；看门狗发现锁丢失时会取消传给 fn 的 ctx。
+// 计数器不能过期，否则 token 会从头开始，默认每个 key 留下一个 <key>:fencing，
+// key 中带有订单号、玩家 id 等数量不断增长的值时用 WithFencingKey 让同一类资源共用一个计数器
+func (sc *DistributedLock) WithLock(ctx context.Context, key string, fn func(ctx context.Context, token int64) error, opts ...LockOption) (err error) {
+	if sc.client == nil {
+		return ErrUnsupported
+	}
+	h, err := sc.LockContext(ctx, key, opts...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// ctx 已经取消时也要释放锁
+		if unlockErr := h.Unlock(context.WithoutCancel(ctx)); unlockErr != nil {
+			err = errors.Join(err, unlockErr)
+		}
+	}()
+
+	fencing := newLockOptions(opts).fencingKey
+	if fencing == "" {
+		fencing = fencingKey(key)
+	}
+	token, err := sc.fencingToken(ctx, key, fencing, h.mutex.Value())
+	if err != nil {
+		return err
+	}
+
+	fnCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-h.Lost():
+			cancel()
+		case <-fnCtx.Done():
+		}
+	}()
+	return fn(fnCtx, token)
+}
+
+func fencingKey(key string) string {
+	return key + ":fencing"
+}
+
+// fencingToken 锁仍然被 value 持有时才发放 token，避免加锁后暂停超过 expiry 的旧持有者拿到比新持有者更大的 token
+func (sc *DistributedLock) fencingToken(ctx context.Context, key, fencing, value string) (int64, error) {
+	token, err := fencingScript.Run(ctx, sc.client, []string{key, fencing}, value).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("fencing token: %w", err)
+	}
+	if token == 0 {
+		return 0, fmt.Errorf("fencing token: %w", ErrNotHeld)
+	}
+	return token, nil
+}
+
+// KEYS: 锁, fencing; ARGV: 锁的值，没有持有锁时返回 0
+var fencingScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+return redis.call('INCR', KEYS[2])
+`)
+
+// lockError 把 redsync 的错误转换为 ErrTaken 或 ErrFailed
+func lockError(err error) error {
+	var (
+		taken     *redsync.ErrTaken
+		nodeTaken *redsync.ErrNodeTaken
+	)
+	if errors.As(err, &taken) || errors.As(err, &nodeTaken) {
+		return fmt.Errorf("%w: %v", ErrTaken, err)
+	}
+	return fmt.Errorf("%w: %v", ErrFailed, err)
+}
+
+// lockBackend 锁的实现，*redsync.Mutex 以及信号量、读写锁的 scriptLock
+type lockBackend interface {
+	Name() string
+	Value() string
+	Until() time.Time
+	ExtendContext(ctx context.Context) (bool, error)
+	UnlockContext(ctx context.Context) (bool, error)
+}
+
+// LockHandle 已持有的锁
+type LockHandle struct {
+	mu     sync.Mutex // redsync.Mutex 不是并发安全的，看门狗和调用方的续期、解锁需要互斥
+	mutex  lockBackend
+	expiry time.Duration
+	stop   chan struct{}
+	done   chan struct{} // 看门狗退出
+	lost   chan struct{}
+	once   sync.Once
+}
+
+func newLockHandle(mutex lockBackend, o lockOptions) *LockHandle {
+	h := &LockHandle{
+		mutex:  mutex,
+		expiry: o.expiry,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		lost:   make(chan struct{}),
+	}
+	if o.watchdog {
+		go h.watch()
+	} else {
+		close(h.done)
+	}
+	return h
+}
+
+// Key 锁的 key
+func (h *LockHandle) Key() string {
+	return h.mutex.Name()
+}
+
+// Until 锁的过期时间
+func (h *LockHandle) Until() time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.mutex.Until()
+}
+
+// Lost 看门狗续期失败、锁已经不再被持有时关闭
+func (h *LockHandle) Lost() <-chan struct{} {
+	return h.lost
+}
+
+// Extend 手动续期一个 expiry
+func (h *LockHandle) Extend(ctx context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if ok, err := h.mutex.ExtendContext(ctx); !ok {
+		return fmt.Errorf("%w: %v", ErrNotHeld, err)
+	}
+	return nil
+}
+
+// Unlock 停止看门狗并释放锁，重复调用返回 ErrNotHeld
+func (h *LockHandle) Unlock(ctx context.Context) error {
+	err := ErrNotHeld
+	h.once.Do(func() {
+		close(h.stop)
+		<-h.done
+
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if ok, unlockErr := h.mutex.UnlockContext(ctx); !ok {
+			getLockMetrics().failure(h.mutex.Name(), "unlock")
+			err = fmt.Errorf("%w: %v", ErrNotHeld, unlockErr)
+			return
+		}
+		err = nil
+	})
+	return err
+}
+
+// watch 每 expiry/3 续期一次，续期失败时关闭 lost 并退出
+func (h *LockHandle) watch() {
+	defer close(h.done)
+
+	interval := h.expiry / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		err := h.Extend(ctx)
+		cancel()
+		if err != nil && time.Now().Before(h.Until()) {
+			// 锁还没过期，下次再试
+			log.Warnf("lock %s extend failed: %v", h.Key(), err)
+			continue
+		}
+		if err != nil {
+			log.Warnf("lock %s lost: %v", h.Key(), err)
+			getLockMetrics().failure(h.Key(), "lost")
+			close(h.lost)
+			return
+		}
+	}
 }
 
 // =====================================================================================
@@ -27,15 +359,15 @@ func (sc *DistributedLock) Lock(key string) (*redsync.Mutex, error) {
 	// 创建一个互斥锁
 	mutex := sc.rs.NewMutex(key)
 	if err := mutex.Lock(); err != nil {
-		//panic(err)
+		return nil, lockError(err)
 	}
 	return mutex, nil
 }
 
 func (sc *DistributedLock) Unlock(mutex *redsync.Mutex) error {
 	// 释放锁
-	if _, err := mutex.Unlock(); err != nil {
-		//panic(err)
+	if ok, err := mutex.Unlock(); !ok {
+		return fmt.Errorf("%w: %v", ErrNotHeld, err)
 	}
 	return nil
 }
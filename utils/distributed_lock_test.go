@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestLock(t *testing.T) (*DistributedLock, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewDistributedLock(client), mr
+}
+
+// waitRenewed 快进 d 后等待 key 被续期到 TTL 大于 d，miniredis 只在快进时过期 key
+func waitRenewed(t *testing.T, mr *miniredis.Miniredis, key string, d time.Duration) {
+	t.Helper()
+	mr.FastForward(d)
+	deadline := time.Now().Add(time.Second)
+	for mr.TTL(key) <= d {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s not renewed, ttl %v", key, mr.TTL(key))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLockContext(t *testing.T) {
+	dl, mr := newTestLock(t)
+	ctx := context.Background()
+
+	h, err := dl.LockContext(ctx, "lock:a", WithExpiry(300*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dl.TryLock(ctx, "lock:a"); !errors.Is(err, ErrTaken) {
+		t.Fatalf("try lock: %v, want ErrTaken", err)
+	}
+
+	// 看门狗续期后累计超过 expiry 仍然持有
+	for i := 0; i < 3; i++ {
+		waitRenewed(t, mr, "lock:a", 200*time.Millisecond)
+	}
+	if _, err := dl.TryLock(ctx, "lock:a"); !errors.Is(err, ErrTaken) {
+		t.Fatalf("try lock after expiry: %v, want ErrTaken", err)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err := dl.LockContext(timeout, "lock:a", WithRetryDelay(10*time.Millisecond)); !errors.Is(err, ErrFailed) {
+		t.Fatalf("lock with timeout: %v, want ErrFailed", err)
+	}
+
+	if err := h.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Unlock(ctx); !errors.Is(err, ErrNotHeld) {
+		t.Errorf("second unlock: %v, want ErrNotHeld", err)
+	}
+
+	// 不续期的锁在 expiry 后释放
+	if _, err = dl.TryLock(ctx, "lock:a", WithExpiry(300*time.Millisecond), WithoutWatchdog()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	mr.FastForward(400 * time.Millisecond)
+	if mr.Exists("lock:a") {
+		t.Error("lock without watchdog should expire")
+	}
+}
+
+func TestLockLost(t *testing.T) {
+	dl, mr := newTestLock(t)
+	h, err := dl.LockContext(context.Background(), "lock:b", WithExpiry(150*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mr.Del("lock:b")
+	mr.Set("lock:b", "other")
+	select {
+	case <-h.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("lost should be closed")
+	}
+}
+
+func TestWithLock(t *testing.T) {
+	dl, mr := newTestLock(t)
+	ctx := context.Background()
+
+	var tokens []int64
+	for i := 0; i < 2; i++ {
+		err := dl.WithLock(ctx, "lock:c", func(ctx context.Context, token int64) error {
+			if _, err := dl.TryLock(ctx, "lock:c"); !errors.Is(err, ErrTaken) {
+				t.Errorf("lock should be held: %v", err)
+			}
+			tokens = append(tokens, token)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(tokens) != 2 || tokens[1] <= tokens[0] {
+		t.Errorf("tokens should increase: %v", tokens)
+	}
+
+	// fn 出错时返回错误并释放锁
+	errFn := errors.New("fn failed")
+	if err := dl.WithLock(ctx, "lock:c", func(ctx context.Context, token int64) error { return errFn }); !errors.Is(err, errFn) {
+		t.Errorf("err = %v, want errFn", err)
+	}
+	h, err := dl.TryLock(ctx, "lock:c")
+	if err != nil {
+		t.Fatalf("lock should be released: %v", err)
+	}
+	h.Unlock(ctx)
+
+	// 同一类资源共用一个计数器，不再为每个 key 留下 <key>:fencing
+	tokens = tokens[:0]
+	for _, key := range []string{"order:1", "order:2"} {
+		err := dl.WithLock(ctx, key, func(ctx context.Context, token int64) error {
+			tokens = append(tokens, token)
+			return nil
+		}, WithFencingKey("fencing:order"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mr.Exists(fencingKey(key)) {
+			t.Errorf("%s should not be created", fencingKey(key))
+		}
+	}
+	if v, _ := mr.Get("fencing:order"); v != "2" || len(tokens) != 2 || tokens[1] <= tokens[0] {
+		t.Errorf("fencing:order = %s, tokens = %v", v, tokens)
+	}
+}
+
+func TestFencingTokenAfterExpiry(t *testing.T) {
+	dl, mr := newTestLock(t)
+	ctx := context.Background()
+
+	h, err := dl.TryLock(ctx, "lock:e", WithoutWatchdog())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token, err := dl.fencingToken(ctx, "lock:e", fencingKey("lock:e"), h.mutex.Value()); err != nil || token != 1 {
+		t.Fatalf("token = %d, err = %v", token, err)
+	}
+
+	// 锁过期后被其他人拿走，旧的持有者不能再拿到 token
+	mr.FastForward(defaultLockExpiry)
+	mr.Set("lock:e", "other")
+	if _, err := dl.fencingToken(ctx, "lock:e", fencingKey("lock:e"), h.mutex.Value()); !errors.Is(err, ErrNotHeld) {
+		t.Errorf("err = %v, want ErrNotHeld", err)
+	}
+	if v, _ := mr.Get("lock:e:fencing"); v != "1" {
+		t.Errorf("fencing = %s, want 1", v)
+	}
+}
+
+func TestRedlock(t *testing.T) {
+	var (
+		nodes   []*miniredis.Miniredis
+		clients []redis.UniversalClient
+	)
+	for i := 0; i < 3; i++ {
+		mr := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+		t.Cleanup(func() { client.Close() })
+		nodes = append(nodes, mr)
+		clients = append(clients, client)
+	}
+	if _, err := NewRedlock(); err == nil {
+		t.Error("redlock without clients should fail")
+	}
+	dl, err := NewRedlock(clients...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// 一个节点不可用时仍然可以加锁
+	nodes[2].Close()
+	h, err := dl.TryLock(ctx, "lock:d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Unlock(ctx)
+
+	// 超过半数节点被占用
+	nodes[0].Set("lock:d", "other")
+	nodes[1].Set("lock:d", "other")
+	if _, err := dl.TryLock(ctx, "lock:d"); !errors.Is(err, ErrTaken) {
+		t.Errorf("err = %v, want ErrTaken", err)
+	}
+
+	// 单节点的原语没有多数派保证
+	if err := dl.WithLock(ctx, "lock:d", func(ctx context.Context, token int64) error { return nil }); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("with lock: %v, want ErrUnsupported", err)
+	}
+	if _, err := dl.NewSemaphore("sem:d", 1); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("semaphore: %v, want ErrUnsupported", err)
+	}
+	if _, err := dl.NewRWLock("rw:d"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("rwlock: %v, want ErrUnsupported", err)
+	}
+
+	if lockKeyPrefix("lock:reward:10001") != "lock:reward" || lockKeyPrefix("single") != "single" {
+		t.Error("unexpected key prefix")
+	}
+}
@@ -0,0 +1,182 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrInProgress 相同幂等 key 的请求正在处理中
+var ErrInProgress = errors.New("idempotency: request in progress")
+
+// ErrMismatch 相同幂等 key 的请求内容和第一次请求不一致
+var ErrMismatch = errors.New("idempotency: key reused with a different request")
+
+// Options 幂等存储选项，nil 时使用默认值
+type Options struct {
+	Prefix    string        // redis key 前缀，默认 idem:
+	LockTTL   time.Duration // 处理中状态的有效期，处理期间每 1/3 个 LockTTL 自动续期，进程崩溃后超时允许重新执行，默认 30 秒
+	ResultTTL time.Duration // 处理结果的保存时间，默认 24 小时
+}
+
+const (
+	defaultPrefix    = "idem:"
+	defaultLockTTL   = 30 * time.Second
+	defaultResultTTL = 24 * time.Hour
+)
+
+// Store 基于 redis 的幂等存储，用于充值、提现、发奖等客户端或网关可能重试的请求
+type Store struct {
+	client redis.Scripter
+	opts   Options
+}
+
+func NewStore(client redis.Scripter, opts *Options) *Store {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.Prefix == "" {
+		o.Prefix = defaultPrefix
+	}
+	if o.LockTTL <= 0 {
+		o.LockTTL = defaultLockTTL
+	}
+	if o.ResultTTL <= 0 {
+		o.ResultTTL = defaultResultTTL
+	}
+	return &Store{client: client, opts: o}
+}
+
+// Do 第一次请求时执行 fn 并保存结果，重复请求直接返回保存的结果（replayed 为 true），
+// 上一次请求还在处理中时返回 ErrInProgress。fn 出错时不保存结果，允许客户端重试。
+// request 为请求内容，保存它的哈希，重复请求的内容不一致时返回 ErrMismatch，nil 表示不校验
+func (s *Store) Do(ctx context.Context, key string, request []byte, fn func(ctx context.Context) ([]byte, error)) (data []byte, replayed bool, err error) {
+	key = s.opts.Prefix + key
+	token := strconv.FormatUint(rand.Uint64(), 36)
+	var hash string
+	if request != nil {
+		sum := sha256.Sum256(request)
+		hash = hex.EncodeToString(sum[:])
+	}
+	values, err := beginScript.Run(ctx, s.client, []string{key}, token, s.opts.LockTTL.Milliseconds(), hash).Slice()
+	if err != nil {
+		return nil, false, err
+	}
+	if values[0].(int64) == 0 {
+		if saved := values[3].(string); hash != "" && saved != "" && saved != hash {
+			return nil, false, ErrMismatch
+		}
+		if values[1] == stateDone {
+			return []byte(values[2].(string)), true, nil
+		}
+		return nil, false, ErrInProgress
+	}
+
+	stop := s.keepPending(key, token)
+	data, err = fn(ctx)
+	stop()
+	// ctx 取消时也要更新状态
+	ctx = context.WithoutCancel(ctx)
+	if err != nil {
+		if abortErr := abortScript.Run(ctx, s.client, []string{key}, token).Err(); abortErr != nil {
+			log.Warnf("idempotency %s abort failed: %v", key, abortErr)
+		}
+		return nil, false, err
+	}
+
+	// 请求已经执行成功，保存失败时只记录日志，不能返回错误让客户端重试
+	ok, completeErr := completeScript.Run(ctx, s.client, []string{key}, token, data, s.opts.ResultTTL.Milliseconds()).Bool()
+	if completeErr != nil {
+		log.Warnf("idempotency %s complete failed: %v", key, completeErr)
+	} else if !ok {
+		log.Warnf("idempotency %s complete failed: pending state lost", key)
+	}
+	return data, false, nil
+}
+
+// keepPending 和 LockHandle 的看门狗一样在 fn 执行期间续期处理中状态，返回的函数停止续期并等待退出
+func (s *Store) keepPending(key, token string) (stop func()) {
+	stopCh, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		interval := s.opts.LockTTL / 3
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			ok, err := extendScript.Run(ctx, s.client, []string{key}, token, s.opts.LockTTL.Milliseconds()).Bool()
+			cancel()
+			if err != nil {
+				// 处理中状态还没过期，下次再试
+				log.Warnf("idempotency %s extend failed: %v", key, err)
+				continue
+			}
+			if !ok {
+				log.Warnf("idempotency %s pending state lost", key)
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stopCh)
+		<-done
+	}
+}
+
+// Forget 删除保存的结果，允许相同 key 重新执行
+func (s *Store) Forget(ctx context.Context, key string) error {
+	return forgetScript.Run(ctx, s.client, []string{s.opts.Prefix + key}).Err()
+}
+
+const stateDone = "done"
+
+// ARGV: token, lock ttl, hash；返回 {1} 表示开始处理，{0, state, data, hash} 表示已经存在
+var beginScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	redis.call('HSET', KEYS[1], 'state', 'pending', 'token', ARGV[1], 'hash', ARGV[3])
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	return {1}
+end
+local v = redis.call('HMGET', KEYS[1], 'state', 'data', 'hash')
+return {0, v[1] or '', v[2] or '', v[3] or ''}
+`)
+
+var extendScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'token') == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+var completeScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'token') ~= ARGV[1] then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'state', 'done', 'data', ARGV[2])
+redis.call('HDEL', KEYS[1], 'token')
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return 1
+`)
+
+var abortScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'token') == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+var forgetScript = redis.NewScript(`return redis.call('DEL', KEYS[1])`)
@@ -0,0 +1,215 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-kratos/kratos/v2/metadata"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func newTestStore(t *testing.T) *Store {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewStore(client, nil)
+}
+
+func TestStoreDo(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	calls := 0
+	fn := func(ctx context.Context) ([]byte, error) {
+		calls++
+		// 处理中的重复请求被拒绝
+		if _, _, err := store.Do(ctx, "pay:1", nil, nil); !errors.Is(err, ErrInProgress) {
+			t.Errorf("err = %v, want ErrInProgress", err)
+		}
+		return []byte("ok"), nil
+	}
+	for i := 0; i < 2; i++ {
+		data, replayed, err := store.Do(ctx, "pay:1", nil, fn)
+		if err != nil || string(data) != "ok" || replayed != (i == 1) {
+			t.Fatalf("do %d: %q %v %v", i, data, replayed, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("fn called %d times, want 1", calls)
+	}
+
+	// 出错时不保存，允许重试
+	errFn := errors.New("failed")
+	if _, _, err := store.Do(ctx, "pay:2", nil, func(ctx context.Context) ([]byte, error) { return nil, errFn }); err != errFn {
+		t.Fatalf("err = %v", err)
+	}
+	if data, replayed, err := store.Do(ctx, "pay:2", nil, func(ctx context.Context) ([]byte, error) { return []byte("retry"), nil }); err != nil || replayed || string(data) != "retry" {
+		t.Fatalf("retry: %q %v %v", data, replayed, err)
+	}
+
+	// 保存请求哈希，相同 key 的请求内容不一致时拒绝
+	ok := func(ctx context.Context) ([]byte, error) { return []byte("ok"), nil }
+	if _, _, err := store.Do(ctx, "pay:3", []byte("amount=1"), ok); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.Do(ctx, "pay:3", []byte("amount=2"), ok); !errors.Is(err, ErrMismatch) {
+		t.Errorf("err = %v, want ErrMismatch", err)
+	}
+	if _, replayed, err := store.Do(ctx, "pay:3", []byte("amount=1"), ok); err != nil || !replayed {
+		t.Errorf("replay: %v %v", replayed, err)
+	}
+}
+
+func TestStorePendingRenewed(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	store := NewStore(client, &Options{LockTTL: 300 * time.Millisecond})
+	ctx := context.Background()
+
+	// 处理时间超过 LockTTL，处理中状态被续期，重复请求仍然被拒绝
+	_, _, err := store.Do(ctx, "pay:1", nil, func(ctx context.Context) ([]byte, error) {
+		for i := 0; i < 3; i++ {
+			mr.FastForward(200 * time.Millisecond)
+			deadline := time.Now().Add(time.Second)
+			for mr.TTL("idem:pay:1") <= 200*time.Millisecond {
+				if time.Now().After(deadline) {
+					t.Fatal("pending state not renewed")
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			if _, _, err := store.Do(ctx, "pay:1", nil, nil); !errors.Is(err, ErrInProgress) {
+				t.Fatalf("err = %v, want ErrInProgress", err)
+			}
+		}
+		return []byte("ok"), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, replayed, err := store.Do(ctx, "pay:1", nil, nil); err != nil || !replayed || string(data) != "ok" {
+		t.Fatalf("replay: %q %v %v", data, replayed, err)
+	}
+}
+
+func TestServerMiddleware(t *testing.T) {
+	store := newTestStore(t)
+	calls := 0
+	handler := Server(store, "")(func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls++
+		return wrapperspb.String("order-1"), nil
+	})
+
+	ctx := metadata.NewServerContext(context.Background(), metadata.Metadata{
+		"x-md-global-uid":             {"10001"},
+		"x-md-global-idempotency-key": {"abc"},
+	})
+	for i := 0; i < 2; i++ {
+		reply, err := handler(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(reply.(proto.Message), wrapperspb.String("order-1")) {
+			t.Errorf("reply = %v", reply)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1", calls)
+	}
+
+	// 没有幂等 key 的请求每次都执行
+	handler(context.Background(), nil)
+	handler(context.Background(), nil)
+	if calls != 3 {
+		t.Errorf("handler called %d times, want 3", calls)
+	}
+}
+
+func TestServerMiddlewareKeyReused(t *testing.T) {
+	store := newTestStore(t)
+	calls := 0
+	handler := Server(store, "")(func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls++
+		return wrapperspb.String("order-1"), nil
+	})
+
+	ctx := metadata.NewServerContext(context.Background(), metadata.Metadata{
+		"x-md-global-uid":             {"10001"},
+		"x-md-global-idempotency-key": {"abc"},
+	})
+	if _, err := handler(ctx, wrapperspb.Int64(100)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handler(ctx, wrapperspb.Int64(200)); !errors.Is(err, ErrKeyReused) {
+		t.Errorf("err = %v, want ErrKeyReused", err)
+	}
+	// 空消息和非空消息也要区分
+	if _, err := handler(ctx, &wrapperspb.Int64Value{}); !errors.Is(err, ErrKeyReused) {
+		t.Errorf("empty request err = %v, want ErrKeyReused", err)
+	}
+	if _, err := handler(ctx, wrapperspb.Int64(100)); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times, want 1", calls)
+	}
+}
+
+func TestServerMiddlewareUnsavedReply(t *testing.T) {
+	store := newTestStore(t)
+	tests := []struct {
+		name  string
+		reply interface{}
+	}{
+		{"not proto", "order-1"},
+		{"marshal failed", wrapperspb.String("\xff")}, // proto3 string 不是合法的 utf-8
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			handler := Server(store, "")(func(ctx context.Context, req interface{}) (interface{}, error) {
+				calls++
+				return tt.reply, nil
+			})
+			ctx := metadata.NewServerContext(context.Background(), metadata.Metadata{
+				"x-md-global-uid":             {"10001"},
+				"x-md-global-idempotency-key": {tt.name},
+			})
+
+			// 执行成功但响应无法保存，重复请求不再执行
+			if reply, err := handler(ctx, nil); err != nil || reply != tt.reply {
+				t.Fatalf("first: %v %v", reply, err)
+			}
+			if _, err := handler(ctx, nil); !errors.Is(err, ErrReplyUnavailable) {
+				t.Errorf("replay err = %v, want ErrReplyUnavailable", err)
+			}
+			if calls != 1 {
+				t.Errorf("handler called %d times, want 1", calls)
+			}
+		})
+	}
+}
+
+func TestServerMiddlewareMissingUid(t *testing.T) {
+	store := newTestStore(t)
+	calls := 0
+	handler := Server(store, "")(func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls++
+		return wrapperspb.String("order-1"), nil
+	})
+
+	ctx := metadata.NewServerContext(context.Background(), metadata.Metadata{
+		"x-md-global-idempotency-key": {"abc"},
+	})
+	if _, err := handler(ctx, nil); !errors.Is(err, ErrMissingUid) {
+		t.Errorf("err = %v, want ErrMissingUid", err)
+	}
+	if calls != 0 {
+		t.Errorf("handler called %d times, want 0", calls)
+	}
+}
@@ -0,0 +1,122 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	kerrors "github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/transport"
+	log "github.com/sirupsen/logrus"
+	rpc "github.com/zuodazuoqianggame/common/utils/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// DefaultKeyHeader 客户端传递幂等 key 的 metadata
+const DefaultKeyHeader = "x-md-global-idempotency-key"
+
+// ErrDuplicateRequest 相同幂等 key 的请求正在处理中
+var ErrDuplicateRequest = kerrors.Conflict("IDEMPOTENCY_IN_PROGRESS", "duplicate request in progress")
+
+// ErrMissingUid 带幂等 key 的请求没有 uid，不同的匿名调用方会共用同一个 key
+var ErrMissingUid = kerrors.BadRequest("IDEMPOTENCY_MISSING_UID", "idempotency key requires uid")
+
+// ErrKeyReused 相同幂等 key 的请求内容和第一次请求不一致
+var ErrKeyReused = kerrors.BadRequest("IDEMPOTENCY_KEY_REUSED", "idempotency key reused with a different request")
+
+// ErrReplyUnavailable 请求已经执行成功，但响应无法保存，重复请求不会再次执行
+var ErrReplyUnavailable = kerrors.Conflict("IDEMPOTENCY_REPLY_UNAVAILABLE", "request already processed, reply unavailable")
+
+// unsavedReply 响应无法保存时写入的标记，anypb 序列化的结果不会以 0 开头
+var unsavedReply = []byte("\x00unsaved")
+
+// Server kratos 服务端幂等中间件，header 为空时使用 DefaultKeyHeader。
+// 幂等 key 按接口和 uid 隔离，没有 key 的请求直接执行，有 key 但没有 uid 的请求返回 ErrMissingUid；
+// proto 请求按确定性序列化的结果校验，相同 key 的请求内容不一致时返回 ErrKeyReused；
+// 响应用 anypb 序列化保存，重复请求返回保存的响应。响应不是 proto 或者序列化失败时
+// 记录日志并保存标记，重复请求返回 ErrReplyUnavailable。
+// 配合 selector 中间件只对充值、提现、发奖等接口生效
+func Server(store *Store, header string) middleware.Middleware {
+	if header == "" {
+		header = DefaultKeyHeader
+	}
+	helper := &rpc.PRCHelper{}
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			idemKey := helper.GetExtra(ctx, header)
+			if idemKey == "" {
+				return handler(ctx, req)
+			}
+			uid := helper.GetUid(ctx)
+			if uid == 0 {
+				return nil, ErrMissingUid
+			}
+			key := strconv.FormatUint(uid, 10) + ":" + idemKey
+			if tr, ok := transport.FromServerContext(ctx); ok {
+				key = tr.Operation() + ":" + key
+			}
+
+			var request []byte
+			if msg, ok := req.(proto.Message); ok {
+				var err error
+				if request, err = (proto.MarshalOptions{Deterministic: true}).Marshal(msg); err != nil {
+					return nil, err
+				}
+				// 空消息序列化为 nil，同样需要校验
+				if request == nil {
+					request = []byte{}
+				}
+			}
+
+			var reply interface{}
+			data, replayed, err := store.Do(ctx, key, request, func(ctx context.Context) ([]byte, error) {
+				var err error
+				if reply, err = handler(ctx, req); err != nil {
+					return nil, err
+				}
+				// 请求已经执行成功，不能返回错误让相同的 key 重新执行
+				data, err := marshalReply(reply)
+				if err != nil {
+					log.Errorf("idempotency %s save reply failed: %v", key, err)
+					return unsavedReply, nil
+				}
+				return data, nil
+			})
+			switch {
+			case errors.Is(err, ErrInProgress):
+				return nil, ErrDuplicateRequest
+			case errors.Is(err, ErrMismatch):
+				return nil, ErrKeyReused
+			case err != nil:
+				return nil, err
+			case !replayed:
+				return reply, nil
+			}
+
+			if len(data) == 0 || bytes.Equal(data, unsavedReply) {
+				return nil, ErrReplyUnavailable
+			}
+			var packed anypb.Any
+			if err := proto.Unmarshal(data, &packed); err != nil {
+				return nil, err
+			}
+			return packed.UnmarshalNew()
+		}
+	}
+}
+
+func marshalReply(reply interface{}) ([]byte, error) {
+	msg, ok := reply.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("reply %T is not a proto message", reply)
+	}
+	packed, err := anypb.New(msg)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(packed)
+}
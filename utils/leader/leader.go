@@ -0,0 +1,179 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zuodazuoqianggame/common/utils"
+)
+
+// Options 选举选项，nil 时使用默认值
+type Options struct {
+	TTL time.Duration // 领导权的有效期，默认 10 秒
+	// RenewDeadline leader 距离上次成功续期超过这个时间就主动让出，必须小于 TTL，
+	// 这样旧 leader 总是在锁过期、其他实例能够成为 leader 之前退出，默认 TTL 的 2/3
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration // 竞选和续期的间隔，必须小于 RenewDeadline，默认 2 秒
+
+	// OnStartedLeading 成为 leader 时在新的 goroutine 中调用，失去领导权时 ctx 被取消，必须尽快返回。
+	// 让出后不再续期，锁最多在 TTL 后过期，回调忽略 ctx 继续执行时可能和新的 leader 同时运行
+	OnStartedLeading func(ctx context.Context)
+	// OnStoppedLeading 失去领导权或退出时调用
+	OnStoppedLeading func()
+}
+
+const (
+	defaultTTL         = 10 * time.Second
+	defaultRetryPeriod = 2 * time.Second
+)
+
+// Elector 基于分布式锁的 leader 选举，用于每日奖励结算、排行榜快照等只能在一个实例上执行的任务
+type Elector struct {
+	lock     *utils.DistributedLock
+	key      string
+	opts     Options
+	leading  atomic.Bool
+	deadline atomic.Int64 // 续期截止时间（纳秒），超过之后即使还没有退出也不再是 leader
+	changes  chan bool
+	mu       sync.Mutex // 保证 changes 中是最新的状态
+}
+
+// New 创建选举，所有实例使用相同的 key
+func New(lock *utils.DistributedLock, key string, opts *Options) *Elector {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.TTL <= 0 {
+		o.TTL = defaultTTL
+	}
+	if o.RenewDeadline <= 0 || o.RenewDeadline >= o.TTL {
+		o.RenewDeadline = o.TTL * 2 / 3
+	}
+	if o.RetryPeriod <= 0 {
+		o.RetryPeriod = defaultRetryPeriod
+	}
+	if o.RetryPeriod >= o.RenewDeadline {
+		o.RetryPeriod = o.RenewDeadline / 3
+	}
+	return &Elector{lock: lock, key: key, opts: o, changes: make(chan bool, 1)}
+}
+
+// IsLeader 当前是否是 leader
+func (e *Elector) IsLeader() bool {
+	return e.leading.Load() && time.Now().UnixNano() < e.deadline.Load()
+}
+
+// Changes 领导权变化通知，只保留最新的状态
+func (e *Elector) Changes() <-chan bool {
+	return e.changes
+}
+
+// Run 持续竞选直到 ctx 结束，退出前主动让出领导权
+func (e *Elector) Run(ctx context.Context) {
+	for {
+		begin := time.Now()
+		h, err := e.lock.TryLock(ctx, e.key, utils.WithExpiry(e.opts.TTL), utils.WithoutWatchdog())
+		switch {
+		case err == nil:
+			e.lead(ctx, h, begin)
+		case !errors.Is(err, utils.ErrTaken) && ctx.Err() == nil:
+			log.Warnf("leader %s campaign failed: %v", e.key, err)
+		}
+
+		timer := time.NewTimer(e.opts.RetryPeriod)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// lead 持有领导权直到续期超时或 ctx 结束，acquired 为开始加锁的时间
+func (e *Elector) lead(ctx context.Context, h *utils.LockHandle, acquired time.Time) {
+	log.Infof("leader %s: started leading", e.key)
+	leaderCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	e.deadline.Store(acquired.Add(e.opts.RenewDeadline).UnixNano())
+	e.setLeading(true)
+	go func() {
+		defer close(done)
+		if e.opts.OnStartedLeading != nil {
+			e.opts.OnStartedLeading(leaderCtx)
+		}
+	}()
+
+	e.renew(ctx, h, acquired)
+	cancel()
+	e.setLeading(false)
+	<-done
+
+	unlockCtx, unlockCancel := context.WithTimeout(context.Background(), e.opts.TTL)
+	defer unlockCancel()
+	if err := h.Unlock(unlockCtx); err != nil {
+		log.Warnf("leader %s: step down failed: %v", e.key, err)
+	}
+	log.Infof("leader %s: stopped leading", e.key)
+	if e.opts.OnStoppedLeading != nil {
+		e.opts.OnStoppedLeading()
+	}
+}
+
+// renew 每 RetryPeriod 续期一次，距离上次成功续期超过 RenewDeadline 或 ctx 结束时返回
+func (e *Elector) renew(ctx context.Context, h *utils.LockHandle, renewed time.Time) {
+	ticker := time.NewTicker(e.opts.RetryPeriod)
+	defer ticker.Stop()
+	for {
+		deadline := renewed.Add(e.opts.RenewDeadline)
+		e.deadline.Store(deadline.UnixNano())
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			log.Warnf("leader %s: renew deadline exceeded", e.key)
+			return
+		case <-ticker.C:
+			timer.Stop()
+		}
+
+		begin := time.Now()
+		renewCtx, cancel := context.WithDeadline(ctx, deadline)
+		err := h.Extend(renewCtx)
+		cancel()
+		if err != nil {
+			log.Warnf("leader %s: renew failed: %v", e.key, err)
+			continue
+		}
+		renewed = begin
+	}
+}
+
+func (e *Elector) setLeading(leading bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.leading.Store(leading)
+	select {
+	case <-e.changes:
+	default:
+	}
+	e.changes <- leading
+}
+
+// WrapCallback 包装 timewheel 的回调，只在 leader 上执行，非 leader 时丢弃。
+// 例如 timewheel.New(time.Second, 60, elector.WrapCallback(onTimer))
+func (e *Elector) WrapCallback(callback func(data interface{})) func(data interface{}) {
+	return func(data interface{}) {
+		if e.IsLeader() {
+			callback(data)
+		}
+	}
+}
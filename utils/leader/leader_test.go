@@ -0,0 +1,116 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/zuodazuoqianggame/common/utils"
+)
+
+func TestElector(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	lock := utils.NewDistributedLock(client)
+	opts := &Options{TTL: 300 * time.Millisecond, RetryPeriod: 20 * time.Millisecond}
+
+	a, b := New(lock, "leader:settle", opts), New(lock, "leader:settle", opts)
+	ctxA, cancelA := context.WithCancel(context.Background())
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+	go a.Run(ctxA)
+	if leading := <-a.Changes(); !leading {
+		t.Fatal("a should become leader")
+	}
+	go b.Run(ctxB)
+
+	// b 不会在 a 持有期间成为 leader，包括累计超过 TTL 之后。
+	// miniredis 只在快进时过期 key，每次快进后等待 a 续期
+	for i := 0; i < 3; i++ {
+		mr.FastForward(200 * time.Millisecond)
+		deadline := time.Now().Add(time.Second)
+		for mr.TTL("leader:settle") <= 200*time.Millisecond {
+			if time.Now().After(deadline) {
+				t.Fatalf("lease not renewed, ttl %v", mr.TTL("leader:settle"))
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		if b.IsLeader() || !a.IsLeader() {
+			t.Fatal("a should stay leader")
+		}
+	}
+	if b.IsLeader() {
+		t.Fatal("b should not be leader")
+	}
+
+	var fired []interface{}
+	callback := b.WrapCallback(func(data interface{}) { fired = append(fired, data) })
+	callback(1)
+
+	cancelA()
+	select {
+	case leading := <-b.Changes():
+		if !leading {
+			t.Fatal("b should become leader")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("b did not take over")
+	}
+	callback(2)
+	if len(fired) != 1 || fired[0] != 2 {
+		t.Errorf("callback should only fire on leader, got %v", fired)
+	}
+}
+
+func TestElectorRenewDeadline(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	defer client.Close()
+	lock := utils.NewDistributedLock(client)
+	stopped := make(chan struct{})
+	opts := &Options{
+		TTL:              time.Minute,
+		RenewDeadline:    200 * time.Millisecond,
+		RetryPeriod:      20 * time.Millisecond,
+		OnStoppedLeading: func() { close(stopped) },
+	}
+
+	a := New(lock, "leader:rank", opts)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.Run(ctx)
+	if leading := <-a.Changes(); !leading {
+		t.Fatal("a should become leader")
+	}
+
+	// redis 不可用时续期失败，超过 RenewDeadline 后让出，此时锁还在有效期内
+	mr.SetError("connection lost")
+	select {
+	case leading := <-a.Changes():
+		if leading {
+			t.Fatal("a should step down")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("a did not step down")
+	}
+	if a.IsLeader() {
+		t.Fatal("a should not be leader")
+	}
+	<-stopped // 释放锁也失败了
+	cancel()
+	mr.SetError("")
+
+	if _, err := lock.TryLock(context.Background(), "leader:rank"); !errors.Is(err, utils.ErrTaken) {
+		t.Fatalf("lease should still be held: %v", err)
+	}
+	mr.FastForward(time.Minute)
+	h, err := lock.TryLock(context.Background(), "leader:rank")
+	if err != nil {
+		t.Fatalf("new leader should acquire after ttl: %v", err)
+	}
+	h.Unlock(context.Background())
+}
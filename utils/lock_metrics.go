@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+// lockMetrics 分布式锁的加锁耗时、竞争和失败次数，按 key 前缀统计
+type lockMetrics struct {
+	duration   metric.Float64Histogram
+	contention metric.Int64Counter
+	failures   metric.Int64Counter
+}
+
+var (
+	lockMetricsOnce sync.Once
+	lockMeter       *lockMetrics
+)
+
+// getLockMetrics 第一次加锁时创建，使用全局的 MeterProvider
+func getLockMetrics() *lockMetrics {
+	lockMetricsOnce.Do(func() {
+		meter := otel.Meter("github.com/zuodazuoqianggame/common/utils")
+		var err error
+		lockMeter = &lockMetrics{}
+		if lockMeter.duration, err = meter.Float64Histogram("lock.acquire.duration",
+			metric.WithDescription("Duration of distributed lock acquisition"), metric.WithUnit("s")); err != nil {
+			log.Warnf("create lock metrics failed: %v", err)
+			lockMeter.duration, _ = noop.Meter{}.Float64Histogram("")
+		}
+		if lockMeter.contention, err = meter.Int64Counter("lock.contention",
+			metric.WithDescription("Number of lock acquisition retries because the lock was taken")); err != nil {
+			log.Warnf("create lock metrics failed: %v", err)
+			lockMeter.contention, _ = noop.Meter{}.Int64Counter("")
+		}
+		if lockMeter.failures, err = meter.Int64Counter("lock.failures",
+			metric.WithDescription("Number of failed lock operations")); err != nil {
+			log.Warnf("create lock metrics failed: %v", err)
+			lockMeter.failures, _ = noop.Meter{}.Int64Counter("")
+		}
+	})
+	return lockMeter
+}
+
+// lockKeyPrefix 去掉 key 最后一段（通常是 uid 等 id），例如 lock:reward:10001 -> lock:reward
+func lockKeyPrefix(key string) string {
+	if i := strings.LastIndexByte(key, ':'); i > 0 {
+		return key[:i]
+	}
+	return key
+}
+
+func (m *lockMetrics) acquired(key string, begin time.Time, err error) {
+	result := "acquired"
+	switch {
+	case errors.Is(err, ErrTaken):
+		result = "taken"
+	case err != nil:
+		result = "failed"
+	}
+	attrs := metric.WithAttributes(attribute.String("prefix", lockKeyPrefix(key)), attribute.String("result", result))
+	m.duration.Record(context.Background(), time.Since(begin).Seconds(), attrs)
+	if err != nil {
+		m.failure(key, result)
+	}
+}
+
+func (m *lockMetrics) contended(key string) {
+	m.contention.Add(context.Background(), 1, metric.WithAttributes(attribute.String("prefix", lockKeyPrefix(key))))
+}
+
+// failure reason: taken / failed / lost / unlock
+func (m *lockMetrics) failure(key string, reason string) {
+	m.failures.Add(context.Background(), 1,
+		metric.WithAttributes(attribute.String("prefix", lockKeyPrefix(key)), attribute.String("reason", reason)))
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// scriptLock 用 lua 脚本实现的锁，信号量和读写锁共用，持有者用随机 token 标识
+type scriptLock struct {
+	client  redis.Scripter
+	name    string
+	keys    []string
+	token   string
+	expiry  time.Duration
+	until   time.Time
+	extend  *redis.Script // KEYS: keys, ARGV: token, expiry(ms)，成功返回 1
+	release *redis.Script // KEYS: keys, ARGV: token，成功返回 1
+}
+
+func newScriptLock(client redis.Scripter, name string, keys []string, o lockOptions, extend, release *redis.Script) *scriptLock {
+	return &scriptLock{
+		client:  client,
+		name:    name,
+		keys:    keys,
+		token:   strconv.FormatUint(rand.Uint64(), 36),
+		expiry:  o.expiry,
+		extend:  extend,
+		release: release,
+	}
+}
+
+func (l *scriptLock) Name() string {
+	return l.name
+}
+
+func (l *scriptLock) Value() string {
+	return l.token
+}
+
+func (l *scriptLock) Until() time.Time {
+	return l.until
+}
+
+// args 脚本的公共参数：token, expiry(ms)。当前时间在脚本里用 redis 的 TIME 获取，不依赖各个客户端的时钟
+func (l *scriptLock) args(extra ...interface{}) []interface{} {
+	return append([]interface{}{l.token, l.expiry.Milliseconds()}, extra...)
+}
+
+// luaNow 脚本里取 redis 服务器当前时间（毫秒），拼在需要比较分数的脚本开头
+const luaNow = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+`
+
+// acquire 尝试一次 script，成功时更新过期时间
+func (l *scriptLock) acquire(ctx context.Context, script *redis.Script, extra ...interface{}) (bool, error) {
+	start := time.Now()
+	ok, err := script.Run(ctx, l.client, l.keys, l.args(extra...)...).Bool()
+	if ok {
+		l.until = start.Add(l.expiry)
+	}
+	return ok, err
+}
+
+func (l *scriptLock) ExtendContext(ctx context.Context) (bool, error) {
+	return l.acquire(ctx, l.extend)
+}
+
+func (l *scriptLock) UnlockContext(ctx context.Context) (bool, error) {
+	return l.release.Run(ctx, l.client, l.keys, l.token).Bool()
+}
+
+// lock 按选项重试 try 直到成功，重试耗尽时返回 ErrTaken，redis 出错或 ctx 结束时返回 ErrFailed
+func (l *scriptLock) lock(ctx context.Context, o lockOptions, tries int, try func(ctx context.Context) (bool, error)) (*LockHandle, error) {
+	begin := time.Now()
+	err := retryLock(ctx, l.name, o, tries, try)
+	getLockMetrics().acquired(l.name, begin, err)
+	if err != nil {
+		return nil, err
+	}
+	return newLockHandle(l, o), nil
+}
+
+func retryLock(ctx context.Context, key string, o lockOptions, tries int, try func(ctx context.Context) (bool, error)) error {
+	var lastErr error
+	for i := 0; i < tries; i++ {
+		if i > 0 {
+			getLockMetrics().contended(key)
+			timer := time.NewTimer(o.delay())
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return fmt.Errorf("%w: %w", ErrFailed, ctx.Err())
+			case <-timer.C:
+			}
+		}
+
+		ok, err := try(ctx)
+		if ok {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w: %w", ErrFailed, ctx.Err())
+		}
+		lastErr = err
+	}
+	if lastErr != nil {
+		return fmt.Errorf("%w: %v", ErrFailed, lastErr)
+	}
+	return ErrTaken
+}
@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/middleware/ratelimit"
+	"github.com/go-kratos/kratos/v2/transport"
+	log "github.com/sirupsen/logrus"
+	rpc "github.com/zuodazuoqianggame/common/utils/grpc"
+)
+
+// KeyFunc 获取限流的 key，返回空字符串时不限流
+type KeyFunc func(ctx context.Context) string
+
+// ByUid 按玩家 uid 限流，未登录的请求不限流
+func ByUid() KeyFunc {
+	helper := &rpc.PRCHelper{}
+	return func(ctx context.Context) string {
+		uid := helper.GetUid(ctx)
+		if uid == 0 {
+			return ""
+		}
+		return "uid:" + strconv.FormatUint(uid, 10)
+	}
+}
+
+// ByRemoteIp 按客户端 ip 限流
+func ByRemoteIp() KeyFunc {
+	helper := &rpc.PRCHelper{}
+	return func(ctx context.Context) string {
+		ip := helper.GetRemoteIp(ctx)
+		if ip == "" {
+			return ""
+		}
+		return "ip:" + ip
+	}
+}
+
+// Server kratos 服务端限流中间件，每个接口单独计数，配合 selector 中间件只对登录、聊天等接口生效。
+// 超限时返回 429 错误，metadata 中 retry-after 为重试等待的毫秒数；redis 出错时放行
+func Server(limiter *Limiter, limit Limit, keyFunc KeyFunc) middleware.Middleware {
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			key := keyFunc(ctx)
+			if key == "" {
+				return handler(ctx, req)
+			}
+			if tr, ok := transport.FromServerContext(ctx); ok {
+				key = tr.Operation() + ":" + key
+			}
+
+			result, err := limiter.Allow(ctx, key, limit)
+			if err != nil {
+				log.Warnf("ratelimit %s failed: %v", key, err)
+				return handler(ctx, req)
+			}
+			if !result.Allowed {
+				return nil, ratelimit.ErrLimitExceed.WithMetadata(map[string]string{
+					"retry-after": strconv.FormatInt(result.RetryAfter.Milliseconds(), 10),
+				})
+			}
+			return handler(ctx, req)
+		}
+	}
+}
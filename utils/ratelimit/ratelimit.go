@@ -0,0 +1,205 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Algorithm 限流算法
+type Algorithm int
+
+const (
+	FixedWindow   Algorithm = iota // 固定窗口计数，窗口边界可能出现 2 倍突发
+	SlidingWindow                  // 滑动窗口日志，精确但每个请求占用一个 zset 成员
+	TokenBucket                    // 令牌桶，平滑限流并允许 Burst 大小的突发
+)
+
+// Limit 限流规则：每 Period 最多 Rate 次
+type Limit struct {
+	Algorithm Algorithm
+	Rate      int
+	Period    time.Duration
+	Burst     int // 令牌桶容量，默认等于 Rate
+}
+
+// PerSecond 每秒 rate 次
+func PerSecond(algorithm Algorithm, rate int) Limit {
+	return Limit{Algorithm: algorithm, Rate: rate, Period: time.Second}
+}
+
+// PerMinute 每分钟 rate 次
+func PerMinute(algorithm Algorithm, rate int) Limit {
+	return Limit{Algorithm: algorithm, Rate: rate, Period: time.Minute}
+}
+
+// Result 限流结果
+type Result struct {
+	Allowed    bool
+	Remaining  int           // 剩余次数
+	RetryAfter time.Duration // 被拒绝时多久后可以重试，允许时为 0
+}
+
+var ErrInvalidLimit = errors.New("ratelimit: invalid limit")
+
+// Limiter 基于 redis lua 脚本的限流器，时间取调用方本地时间，多实例之间需要时钟同步
+type Limiter struct {
+	client redis.Scripter
+	prefix string
+	now    func() time.Time
+}
+
+// Option 限流器选项
+type Option func(*Limiter)
+
+// WithClock 替换获取当前时间的函数，默认 time.Now，测试时用来控制时间
+func WithClock(now func() time.Time) Option {
+	return func(l *Limiter) { l.now = now }
+}
+
+// NewLimiter 创建限流器，client 可以是 utils.InitRedis 或 DBManager 获取的客户端，key 会加上 prefix
+func NewLimiter(client redis.Scripter, prefix string, opts ...Option) *Limiter {
+	l := &Limiter{client: client, prefix: prefix, now: time.Now}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Allow 请求一次
+func (l *Limiter) Allow(ctx context.Context, key string, limit Limit) (*Result, error) {
+	return l.AllowN(ctx, key, limit, 1)
+}
+
+// AllowN 请求 n 次，被拒绝时不消耗次数
+func (l *Limiter) AllowN(ctx context.Context, key string, limit Limit, n int) (*Result, error) {
+	if limit.Rate <= 0 || limit.Period <= 0 || n <= 0 {
+		return nil, ErrInvalidLimit
+	}
+
+	key = l.prefix + key
+	now := l.now().UnixMilli()
+	period := limit.Period.Milliseconds()
+	var (
+		values []interface{}
+		err    error
+	)
+	switch limit.Algorithm {
+	case FixedWindow:
+		if n > limit.Rate {
+			return nil, ErrInvalidLimit
+		}
+		values, err = fixedWindowScript.Run(ctx, l.client, []string{key}, limit.Rate, period, n).Slice()
+	case SlidingWindow:
+		if n > limit.Rate {
+			return nil, ErrInvalidLimit
+		}
+		member := strconv.FormatUint(rand.Uint64(), 36)
+		values, err = slidingWindowScript.Run(ctx, l.client, []string{key}, limit.Rate, period, n, now, member).Slice()
+	case TokenBucket:
+		burst := limit.Burst
+		if burst <= 0 {
+			burst = limit.Rate
+		}
+		if n > burst {
+			return nil, ErrInvalidLimit
+		}
+		perMs := float64(limit.Rate) / float64(period)
+		values, err = tokenBucketScript.Run(ctx, l.client, []string{key}, burst, strconv.FormatFloat(perMs, 'f', -1, 64), n, now).Slice()
+	default:
+		return nil, fmt.Errorf("ratelimit: unknown algorithm %d", limit.Algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		Allowed:    values[0].(int64) == 1,
+		Remaining:  int(values[1].(int64)),
+		RetryAfter: time.Duration(values[2].(int64)) * time.Millisecond,
+	}, nil
+}
+
+// Reset 清除 key 的限流记录
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	return resetScript.Run(ctx, l.client, []string{l.prefix + key}).Err()
+}
+
+// 返回 {是否允许, 剩余次数, 重试等待毫秒}
+var fixedWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl < 0 then
+	current = 0
+	ttl = window
+end
+if current + n > limit then
+	return {0, limit - current, ttl}
+end
+
+current = redis.call('INCRBY', KEYS[1], n)
+redis.call('PEXPIRE', KEYS[1], ttl)
+return {1, limit - current, 0}
+`)
+
+var slidingWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+if count + n > limit then
+	-- 等到第 count+n-limit 条最早的记录过期
+	local oldest = redis.call('ZRANGE', KEYS[1], count + n - limit - 1, count + n - limit - 1, 'WITHSCORES')
+	return {0, limit - count, math.max(tonumber(oldest[2]) + window - now, 1)}
+end
+
+for i = 1, n do
+	redis.call('ZADD', KEYS[1], now, ARGV[5] .. ':' .. i)
+end
+redis.call('PEXPIRE', KEYS[1], window)
+return {1, limit - count - n, 0}
+`)
+
+var tokenBucketScript = redis.NewScript(`
+local burst = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+
+local data = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(data[1])
+local ts = tonumber(data[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+ts = math.max(ts, now)
+
+local allowed = 0
+local retry = 0
+if tokens >= n then
+	tokens = tokens - n
+	allowed = 1
+else
+	retry = math.ceil((n - tokens) / rate)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', ts)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate))
+return {allowed, math.floor(tokens), retry}
+`)
+
+var resetScript = redis.NewScript(`return redis.call('DEL', KEYS[1])`)
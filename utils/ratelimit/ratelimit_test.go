@@ -0,0 +1,194 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	kerrors "github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-kratos/kratos/v2/metadata"
+	"github.com/go-kratos/kratos/v2/transport"
+	"github.com/redis/go-redis/v9"
+)
+
+// testClock 同时推进本地时间和 miniredis 的时间，固定窗口依赖 key 的过期时间
+type testClock struct {
+	mr  *miniredis.Miniredis
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+	c.mr.FastForward(d)
+}
+
+func newTestLimiter(t *testing.T) (*Limiter, *testClock) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	clock := &testClock{mr: mr, now: time.Unix(1700000000, 0)}
+	return NewLimiter(client, "rl:", WithClock(clock.Now)), clock
+}
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	for _, algorithm := range []Algorithm{FixedWindow, SlidingWindow, TokenBucket} {
+		limiter, _ := newTestLimiter(t)
+		limit := PerMinute(algorithm, 3)
+		for i := 0; i < 3; i++ {
+			result, err := limiter.Allow(ctx, "uid:1", limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.Allowed || result.Remaining != 2-i {
+				t.Fatalf("algorithm %d request %d: %+v", algorithm, i, result)
+			}
+		}
+
+		result, err := limiter.Allow(ctx, "uid:1", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Allowed || result.Remaining != 0 || result.RetryAfter <= 0 || result.RetryAfter > time.Minute {
+			t.Errorf("algorithm %d: expected rejection, got %+v", algorithm, result)
+		}
+
+		// 其他 key 不受影响
+		if result, _ := limiter.Allow(ctx, "uid:2", limit); !result.Allowed {
+			t.Errorf("algorithm %d: uid:2 should be allowed", algorithm)
+		}
+
+		if err := limiter.Reset(ctx, "uid:1"); err != nil {
+			t.Fatal(err)
+		}
+		if result, _ := limiter.Allow(ctx, "uid:1", limit); !result.Allowed {
+			t.Errorf("algorithm %d: should be allowed after reset", algorithm)
+		}
+	}
+}
+
+func TestLimiterRetryAfter(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		algorithm Algorithm
+		gap       time.Duration // 前 3 个请求之间的间隔
+		remaining int           // 重试成功后的剩余次数
+	}{
+		{FixedWindow, 20 * time.Second, 2},   // 窗口在 60 秒后重置
+		{SlidingWindow, 20 * time.Second, 0}, // 只有最早的一条记录过期
+		{TokenBucket, 0, 0},                  // 每 20 秒补充一个令牌
+	}
+	for _, tt := range tests {
+		limiter, clock := newTestLimiter(t)
+		limit := PerMinute(tt.algorithm, 3)
+		allow := func() *Result {
+			t.Helper()
+			result, err := limiter.Allow(ctx, "uid:1", limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return result
+		}
+		for i := 0; i < 3; i++ {
+			if i > 0 {
+				clock.advance(tt.gap)
+			}
+			if result := allow(); !result.Allowed {
+				t.Fatalf("algorithm %d request %d: %+v", tt.algorithm, i, result)
+			}
+		}
+
+		// 窗口算法在第一个请求 40 秒后被拒绝，令牌桶在令牌用完时被拒绝，都需要等 20 秒
+		result := allow()
+		if result.Allowed || result.RetryAfter != 20*time.Second {
+			t.Fatalf("algorithm %d: %+v, want retry after 20s", tt.algorithm, result)
+		}
+		clock.advance(10 * time.Second)
+		if result := allow(); result.Allowed || result.RetryAfter != 10*time.Second {
+			t.Fatalf("algorithm %d: %+v, want retry after 10s", tt.algorithm, result)
+		}
+		clock.advance(10 * time.Second)
+		if result := allow(); !result.Allowed || result.Remaining != tt.remaining {
+			t.Errorf("algorithm %d: %+v, want allowed with %d remaining", tt.algorithm, result, tt.remaining)
+		}
+	}
+}
+
+func TestLimiterInvalid(t *testing.T) {
+	limiter, _ := newTestLimiter(t)
+	if _, err := limiter.AllowN(context.Background(), "k", PerSecond(SlidingWindow, 2), 3); err != ErrInvalidLimit {
+		t.Errorf("err = %v, want ErrInvalidLimit", err)
+	}
+}
+
+// testTransport 只提供 Operation，用来构造服务端 ctx
+type testTransport struct {
+	transport.Transporter
+	operation string
+}
+
+func (tr testTransport) Operation() string {
+	return tr.operation
+}
+
+func TestServerMiddleware(t *testing.T) {
+	limiter, clock := newTestLimiter(t)
+	calls := 0
+	handler := func(keyFunc KeyFunc) func(ctx context.Context) error {
+		h := Server(limiter, PerMinute(FixedWindow, 1), keyFunc)(func(ctx context.Context, req interface{}) (interface{}, error) {
+			calls++
+			return nil, nil
+		})
+		return func(ctx context.Context) error {
+			_, err := h(ctx, nil)
+			return err
+		}
+	}
+	newCtx := func(operation string, md metadata.Metadata) context.Context {
+		ctx := metadata.NewServerContext(context.Background(), md)
+		return transport.NewServerContext(ctx, testTransport{operation: operation})
+	}
+
+	// key 由接口和 uid 或 ip 组成
+	byUid, byIp := handler(ByUid()), handler(ByRemoteIp())
+	login := newCtx("/api.User/Login", metadata.Metadata{"x-md-global-uid": {"10001"}, "x-md-global-remote_ip": {"1.2.3.4"}})
+	chat := newCtx("/api.Chat/Send", metadata.Metadata{"x-md-global-uid": {"10001"}})
+	for _, err := range []error{byUid(login), byIp(login), byUid(chat)} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{"rl:/api.User/Login:uid:10001", "rl:/api.User/Login:ip:1.2.3.4", "rl:/api.Chat/Send:uid:10001"} {
+		if !clock.mr.Exists(key) {
+			t.Errorf("key %s not found in %v", key, clock.mr.Keys())
+		}
+	}
+
+	// 超限时返回 429，retry-after 为等待的毫秒数
+	clock.advance(15 * time.Second)
+	err := byUid(login)
+	e := kerrors.FromError(err)
+	if e.Code != 429 || e.Metadata["retry-after"] != "45000" {
+		t.Errorf("err = %v, metadata = %v", err, e.Metadata)
+	}
+	if calls != 3 {
+		t.Errorf("handler called %d times, want 3", calls)
+	}
+
+	// 没有 key 的请求和 redis 出错时放行
+	if err := byUid(newCtx("/api.User/Login", metadata.Metadata{})); err != nil {
+		t.Error(err)
+	}
+	clock.mr.SetError("redis down")
+	if err := byUid(login); err != nil {
+		t.Error(err)
+	}
+	if calls != 5 {
+		t.Errorf("handler called %d times, want 5", calls)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"net/url"
 	"os"
 	"time"
 
@@ -36,52 +37,237 @@ func InitRedis(addr, password string, db int, enableTls, skipVerifyTls bool, caC
 	}
 
 	if enableTls {
-		tlsConfig := &tls.Config{
-			MinVersion:         tls.VersionTLS12,
-			InsecureSkipVerify: skipVerifyTls,
+		tlsConfig, err := newTLSConfig(skipVerifyTls, caCertPath)
+		if err != nil {
+			return nil, err
 		}
+		opt.TLSConfig = tlsConfig
+	}
 
-		// 如果没有跳过校验 && 提供了 caCertPath，就加载 CA 证书
-		if !skipVerifyTls && caCertPath != "" {
-			caCert, err := os.ReadFile(caCertPath)
-			if err != nil {
-				return nil, fmt.Errorf("failed to load CA cert file: %w", err)
-			}
-			caCertPool := x509.NewCertPool()
-			if !caCertPool.AppendCertsFromPEM(caCert) {
-				return nil, fmt.Errorf("failed to append CA certificate")
-			}
-			tlsConfig.RootCAs = caCertPool
-		}
+	client := redis.NewClient(opt)
+	if err := pingRedis(client); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
 
-		opt.TLSConfig = tlsConfig
+// InitRedisByDNS 通过 DSN 初始化单节点 Redis 客户端
+//
+// DSN 格式：redis://[user:password@]host:port/db?ssl=true&skip_verify=false&ca_cert=/path/ca.pem
+//   - ssl / skip_verify / ca_cert 与 InitRedis 的 TLS 参数含义相同，rediss:// 等同于 ssl=true
+//   - 其它参数（dial_timeout、pool_size 等）由 go-redis 解析
+func InitRedisByDNS(dsn string) (*redis.Client, error) {
+	opt, err := ParseRedisURL(dsn)
+	if err != nil {
+		return nil, err
 	}
 
 	client := redis.NewClient(opt)
+	if err := pingRedis(client); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
 
-	// 测试连接，超时控制
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-	if err := client.Ping(ctx).Err(); err != nil {
-		return nil, fmt.Errorf("failed to connect redis: %w", err)
+// InitRedisCluster 初始化 Redis 集群客户端，例如 AWS ElastiCache 集群模式
+//   - addrs: 集群节点地址，至少一个，其余节点会自动发现
+//   - 其它参数与 InitRedis 相同
+func InitRedisCluster(addrs []string, password string, enableTls, skipVerifyTls bool, caCertPath string) (*redis.ClusterClient, error) {
+	opt := &redis.ClusterOptions{
+		Addrs:    addrs,
+		Password: password,
 	}
 
+	if enableTls {
+		tlsConfig, err := newTLSConfig(skipVerifyTls, caCertPath)
+		if err != nil {
+			return nil, err
+		}
+		opt.TLSConfig = tlsConfig
+	}
+
+	client := redis.NewClusterClient(opt)
+	if err := pingRedis(client); err != nil {
+		return nil, err
+	}
 	return client, nil
 }
 
-func InitRedisByDNS(dsn string) (*redis.Client, error) {
+// InitRedisSentinel 通过哨兵初始化 Redis 客户端，主从切换后自动连接新的主节点
+//   - masterName: 哨兵中配置的主节点名称
+//   - sentinelAddrs: 哨兵地址列表
+//   - 其它参数与 InitRedis 相同，password 是主节点的密码
+func InitRedisSentinel(masterName string, sentinelAddrs []string, password string, db int, enableTls, skipVerifyTls bool, caCertPath string) (*redis.Client, error) {
+	opt := &redis.FailoverOptions{
+		MasterName:    masterName,
+		SentinelAddrs: sentinelAddrs,
+		Password:      password,
+		DB:            db,
+	}
+
+	if enableTls {
+		tlsConfig, err := newTLSConfig(skipVerifyTls, caCertPath)
+		if err != nil {
+			return nil, err
+		}
+		opt.TLSConfig = tlsConfig
+	}
+
+	client := redis.NewFailoverClient(opt)
+	if err := pingRedis(client); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// ParseRedisURL 解析单节点 DSN，格式见 InitRedisByDNS
+func ParseRedisURL(dsn string) (*redis.Options, error) {
+	dsn, tlsConfig, err := splitTLSParams(dsn)
+	if err != nil {
+		return nil, err
+	}
 	opt, err := redis.ParseURL(dsn)
 	if err != nil {
 		return nil, err
 	}
+	opt.TLSConfig = tlsConfig
+	return opt, nil
+}
 
-	client := redis.NewClient(opt)
+// ParseRedisClusterURL 解析集群 DSN，多个节点用 addr 参数追加
+//
+//	redis://:password@host1:6379?addr=host2:6379&addr=host3:6379&ssl=true
+func ParseRedisClusterURL(dsn string) (*redis.ClusterOptions, error) {
+	dsn, tlsConfig, err := splitTLSParams(dsn)
+	if err != nil {
+		return nil, err
+	}
+	opt, err := redis.ParseClusterURL(dsn)
+	if err != nil {
+		return nil, err
+	}
+	opt.TLSConfig = tlsConfig
+	return opt, nil
+}
+
+// ParseRedisSentinelURL 解析哨兵 DSN，host 和 addr 参数是哨兵地址，
+// userinfo 是哨兵的账号密码，主节点的账号密码通过 username / password 参数传入
+//
+//	redis://sentinel1:26379/0?addr=sentinel2:26379&master_name=mymaster&password=xxx
+func ParseRedisSentinelURL(dsn string) (*redis.FailoverOptions, error) {
+	dsn, tlsConfig, err := splitTLSParams(dsn)
+	if err != nil {
+		return nil, err
+	}
+	opt, err := redis.ParseFailoverURL(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if opt.MasterName == "" {
+		return nil, fmt.Errorf("redis sentinel: master_name is required")
+	}
+	opt.TLSConfig = tlsConfig
+	return opt, nil
+}
+
+// ParseRedisRingURL 解析分片 DSN，host 和 addr 参数是各个分片的地址，分片名为 shard0、shard1...
+//
+//	redis://:password@host1:6379/0?addr=host2:6379
+func ParseRedisRingURL(dsn string) (*redis.RingOptions, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	addrs := q["addr"]
+	q.Del("addr")
+	u.RawQuery = q.Encode()
+
+	// 第一个分片的参数作为所有分片的公共参数
+	opt, err := ParseRedisURL(u.String())
+	if err != nil {
+		return nil, err
+	}
+
+	ring := &redis.RingOptions{
+		Addrs:           map[string]string{"shard0": opt.Addr},
+		Username:        opt.Username,
+		Password:        opt.Password,
+		DB:              opt.DB,
+		MaxRetries:      opt.MaxRetries,
+		DialTimeout:     opt.DialTimeout,
+		ReadTimeout:     opt.ReadTimeout,
+		WriteTimeout:    opt.WriteTimeout,
+		PoolSize:        opt.PoolSize,
+		MinIdleConns:    opt.MinIdleConns,
+		MaxIdleConns:    opt.MaxIdleConns,
+		ConnMaxIdleTime: opt.ConnMaxIdleTime,
+		ConnMaxLifetime: opt.ConnMaxLifetime,
+		TLSConfig:       opt.TLSConfig,
+	}
+	for i, addr := range addrs {
+		ring.Addrs[fmt.Sprintf("shard%d", i+1)] = addr
+	}
+	return ring, nil
+}
+
+// splitTLSParams 取出 go-redis 不认识的 ssl / skip_verify / ca_cert 参数并生成 TLS 配置
+func splitTLSParams(dsn string) (string, *tls.Config, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", nil, err
+	}
+
+	q := u.Query()
+	enableTls := u.Scheme == "rediss" || q.Get("ssl") == "true"
+	skipVerifyTls := q.Get("skip_verify") == "true"
+	caCertPath := q.Get("ca_cert")
+	q.Del("ssl")
+	q.Del("skip_verify")
+	q.Del("ca_cert")
+	u.RawQuery = q.Encode()
+	if u.Scheme == "rediss" {
+		u.Scheme = "redis"
+	}
+
+	if !enableTls {
+		return u.String(), nil, nil
+	}
+	tlsConfig, err := newTLSConfig(skipVerifyTls, caCertPath)
+	if err != nil {
+		return "", nil, err
+	}
+	return u.String(), tlsConfig, nil
+}
+
+func newTLSConfig(skipVerifyTls bool, caCertPath string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: skipVerifyTls,
+	}
+
+	// 如果没有跳过校验 && 提供了 caCertPath，就加载 CA 证书
+	if !skipVerifyTls && caCertPath != "" {
+		caCert, err := os.ReadFile(caCertPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load CA cert file: %w", err)
+		}
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("failed to append CA certificate")
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+	return tlsConfig, nil
+}
+
+func pingRedis(client redis.UniversalClient) error {
 	// 测试连接，超时控制
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	if err := client.Ping(ctx).Err(); err != nil {
-		return nil, fmt.Errorf("failed to connect redis: %w", err)
+		client.Close()
+		return fmt.Errorf("failed to connect redis: %w", err)
 	}
-
-	return client, nil
+	return nil
 }
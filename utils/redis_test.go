@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestParseRedisURL(t *testing.T) {
+	opt, err := ParseRedisURL("redis://:123456@localhost:6379/2?ssl=true&skip_verify=true&pool_size=20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opt.Addr != "localhost:6379" || opt.Password != "123456" || opt.DB != 2 || opt.PoolSize != 20 {
+		t.Errorf("unexpected options: %+v", opt)
+	}
+	if opt.TLSConfig == nil || !opt.TLSConfig.InsecureSkipVerify {
+		t.Errorf("tls config not applied: %+v", opt.TLSConfig)
+	}
+
+	opt, err = ParseRedisURL("redis://localhost:6379/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opt.TLSConfig != nil {
+		t.Error("tls should be disabled")
+	}
+}
+
+func TestParseRedisClusterURL(t *testing.T) {
+	opt, err := ParseRedisClusterURL("rediss://:123456@node1:6379?addr=node2:6379&addr=node3:6379")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opt.Addrs) != 3 || opt.Addrs[2] != "node3:6379" {
+		t.Errorf("addrs = %v", opt.Addrs)
+	}
+	if opt.TLSConfig == nil {
+		t.Error("rediss should enable tls")
+	}
+}
+
+func TestParseRedisSentinelURL(t *testing.T) {
+	opt, err := ParseRedisSentinelURL("redis://s1:26379/1?addr=s2:26379&master_name=mymaster&password=123456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opt.MasterName != "mymaster" || opt.Password != "123456" || opt.DB != 1 || len(opt.SentinelAddrs) != 2 {
+		t.Errorf("unexpected options: %+v", opt)
+	}
+
+	if _, err := ParseRedisSentinelURL("redis://s1:26379/1"); err == nil {
+		t.Error("expected error without master_name")
+	}
+}
+
+func TestParseRedisRingURL(t *testing.T) {
+	opt, err := ParseRedisRingURL("redis://:123456@h1:6379/0?addr=h2:6379&dial_timeout=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opt.Addrs) != 2 || opt.Addrs["shard0"] != "h1:6379" || opt.Addrs["shard1"] != "h2:6379" {
+		t.Errorf("addrs = %v", opt.Addrs)
+	}
+	if opt.Password != "123456" || opt.DialTimeout.Seconds() != 3 {
+		t.Errorf("unexpected options: %+v", opt)
+	}
+}
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RWLock 分布式读写锁，适合配置热更新：读锁可以同时被多个持有者持有，写锁独占。
+// 写锁等待读锁释放期间会阻止新的读锁，避免写锁饿死
+type RWLock struct {
+	client    redis.Scripter
+	key       string
+	writeKey  string // 写锁持有者的 token
+	readKey   string // 读锁持有者的 zset
+	intentKey string // 正在等待的写锁
+}
+
+// NewRWLock 创建读写锁，几个 key 使用相同的 hash tag，集群模式下在同一个 slot，Redlock 返回 ErrUnsupported
+func (sc *DistributedLock) NewRWLock(key string) (*RWLock, error) {
+	if sc.client == nil {
+		return nil, ErrUnsupported
+	}
+	return &RWLock{
+		client:    sc.client,
+		key:       key,
+		writeKey:  "{" + key + "}:write",
+		readKey:   "{" + key + "}:read",
+		intentKey: "{" + key + "}:intent",
+	}, nil
+}
+
+// RLock 获取读锁，有写锁或等待中的写锁时按选项重试
+func (rw *RWLock) RLock(ctx context.Context, opts ...LockOption) (*LockHandle, error) {
+	o := newLockOptions(opts)
+	return rw.rlock(ctx, o, o.tries)
+}
+
+// TryRLock 只尝试一次获取读锁，失败时返回 ErrTaken
+func (rw *RWLock) TryRLock(ctx context.Context, opts ...LockOption) (*LockHandle, error) {
+	return rw.rlock(ctx, newLockOptions(opts), 1)
+}
+
+// Lock 获取写锁，有其他读锁或写锁时按选项重试
+func (rw *RWLock) Lock(ctx context.Context, opts ...LockOption) (*LockHandle, error) {
+	o := newLockOptions(opts)
+	return rw.lock(ctx, o, o.tries)
+}
+
+// TryLock 只尝试一次获取写锁，失败时返回 ErrTaken，不会阻止新的读锁
+func (rw *RWLock) TryLock(ctx context.Context, opts ...LockOption) (*LockHandle, error) {
+	return rw.lock(ctx, newLockOptions(opts), 1)
+}
+
+func (rw *RWLock) rlock(ctx context.Context, o lockOptions, tries int) (*LockHandle, error) {
+	keys := []string{rw.readKey, rw.writeKey, rw.intentKey}
+	l := newScriptLock(rw.client, rw.key, keys, o, holderExtendScript, holderReleaseScript)
+	return l.lock(ctx, o, tries, func(ctx context.Context) (bool, error) {
+		return l.acquire(ctx, readLockScript)
+	})
+}
+
+func (rw *RWLock) lock(ctx context.Context, o lockOptions, tries int) (*LockHandle, error) {
+	// 等待期间每次重试都会刷新意向锁，放弃等待后最多阻塞读锁两个重试间隔
+	var intentTTL int64
+	if tries > 1 {
+		intentTTL = 2 * max(o.retryDelay, maxLockRetryDelay).Milliseconds()
+	}
+	keys := []string{rw.writeKey, rw.readKey, rw.intentKey}
+	l := newScriptLock(rw.client, rw.key, keys, o, writeExtendScript, writeReleaseScript)
+	return l.lock(ctx, o, tries, func(ctx context.Context) (bool, error) {
+		return l.acquire(ctx, writeLockScript, intentTTL)
+	})
+}
+
+// KEYS: read, write, intent; ARGV: token, expiry
+var readLockScript = redis.NewScript(luaNow + `
+if redis.call('EXISTS', KEYS[2]) == 1 or redis.call('EXISTS', KEYS[3]) == 1 then
+	return 0
+end
+local expiry = tonumber(ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+redis.call('ZADD', KEYS[1], now + expiry, ARGV[1])
+if redis.call('PTTL', KEYS[1]) < expiry then
+	redis.call('PEXPIRE', KEYS[1], expiry)
+end
+return 1
+`)
+
+// KEYS: write, read, intent; ARGV: token, expiry, intent ttl（0 表示不设置意向锁）
+var writeLockScript = redis.NewScript(luaNow + `
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+local intent = redis.call('GET', KEYS[3])
+if intent and intent ~= ARGV[1] then
+	return 0
+end
+redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', now)
+if redis.call('ZCARD', KEYS[2]) > 0 then
+	if tonumber(ARGV[3]) > 0 then
+		redis.call('SET', KEYS[3], ARGV[1], 'PX', ARGV[3])
+	end
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+if intent then
+	redis.call('DEL', KEYS[3])
+end
+return 1
+`)
+
+var writeExtendScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+var writeReleaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Semaphore 分布式计数信号量，最多 limit 个持有者同时持有，例如整个集群最多 50 个匹配任务。
+// 每个持有者是 zset 中的一个成员，分数为过期时间，持有者崩溃后到期自动释放
+type Semaphore struct {
+	client redis.Scripter
+	key    string
+	limit  int
+}
+
+// NewSemaphore 创建信号量，同一个 key 的 limit 需要保持一致，Redlock 返回 ErrUnsupported
+func (sc *DistributedLock) NewSemaphore(key string, limit int) (*Semaphore, error) {
+	if sc.client == nil {
+		return nil, ErrUnsupported
+	}
+	return &Semaphore{client: sc.client, key: key, limit: limit}, nil
+}
+
+// Acquire 获取一个许可，没有空闲许可时按选项重试，返回的 LockHandle 必须调用 Unlock 释放
+func (s *Semaphore) Acquire(ctx context.Context, opts ...LockOption) (*LockHandle, error) {
+	o := newLockOptions(opts)
+	return s.acquire(ctx, o, o.tries)
+}
+
+// TryAcquire 只尝试一次，没有空闲许可时返回 ErrTaken
+func (s *Semaphore) TryAcquire(ctx context.Context, opts ...LockOption) (*LockHandle, error) {
+	return s.acquire(ctx, newLockOptions(opts), 1)
+}
+
+func (s *Semaphore) acquire(ctx context.Context, o lockOptions, tries int) (*LockHandle, error) {
+	l := newScriptLock(s.client, s.key, []string{s.key}, o, holderExtendScript, holderReleaseScript)
+	return l.lock(ctx, o, tries, func(ctx context.Context) (bool, error) {
+		return l.acquire(ctx, semaphoreAcquireScript, s.limit)
+	})
+}
+
+// ARGV: token, expiry, limit
+var semaphoreAcquireScript = redis.NewScript(luaNow + `
+local expiry = tonumber(ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[3]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], now + expiry, ARGV[1])
+if redis.call('PTTL', KEYS[1]) < expiry then
+	redis.call('PEXPIRE', KEYS[1], expiry)
+end
+return 1
+`)
+
+// holderExtendScript 续期 zset 中的持有者，信号量和读锁共用，KEYS[1] 为 zset
+var holderExtendScript = redis.NewScript(luaNow + `
+local key = KEYS[1]
+local expiry = tonumber(ARGV[2])
+local score = redis.call('ZSCORE', key, ARGV[1])
+if not score or tonumber(score) < now then
+	return 0
+end
+redis.call('ZADD', key, now + expiry, ARGV[1])
+if redis.call('PTTL', key) < expiry then
+	redis.call('PEXPIRE', key, expiry)
+end
+return 1
+`)
+
+var holderReleaseScript = redis.NewScript(`
+return redis.call('ZREM', KEYS[1], ARGV[1])
+`)
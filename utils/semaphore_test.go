@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSemaphore(t *testing.T) {
+	dl, _ := newTestLock(t)
+	ctx := context.Background()
+	sem, err := dl.NewSemaphore("sem:match", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := sem.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := sem.TryAcquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sem.TryAcquire(ctx); !errors.Is(err, ErrTaken) {
+		t.Fatalf("err = %v, want ErrTaken", err)
+	}
+	if err := b.Extend(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Unlock(ctx)
+	c, err := sem.TryAcquire(ctx)
+	if err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+	b.Unlock(ctx)
+	c.Unlock(ctx)
+	if err := c.Unlock(ctx); !errors.Is(err, ErrNotHeld) {
+		t.Errorf("second unlock: %v", err)
+	}
+}
+
+func TestRWLock(t *testing.T) {
+	dl, _ := newTestLock(t)
+	ctx := context.Background()
+	rw, err := dl.NewRWLock("config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r1, err := rw.RLock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := rw.TryRLock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rw.TryLock(ctx); !errors.Is(err, ErrTaken) {
+		t.Fatalf("try write lock: %v, want ErrTaken", err)
+	}
+
+	// 写锁等待时阻止新的读锁
+	done := make(chan *LockHandle)
+	go func() {
+		w, err := rw.Lock(ctx, WithRetryDelay(10*time.Millisecond))
+		if err != nil {
+			t.Error(err)
+		}
+		done <- w
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if _, err := rw.TryRLock(ctx); !errors.Is(err, ErrTaken) {
+		t.Fatalf("read lock while writer waiting: %v, want ErrTaken", err)
+	}
+
+	r1.Unlock(ctx)
+	r2.Unlock(ctx)
+	w := <-done
+	if _, err := rw.TryRLock(ctx); !errors.Is(err, ErrTaken) {
+		t.Fatalf("read lock while write locked: %v, want ErrTaken", err)
+	}
+	if err := w.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	r, err := rw.TryRLock(ctx)
+	if err != nil {
+		t.Fatalf("read lock after write unlock: %v", err)
+	}
+	r.Unlock(ctx)
+}
+
+func TestSemaphoreServerClock(t *testing.T) {
+	dl, mr := newTestLock(t)
+	ctx := context.Background()
+	sem, _ := dl.NewSemaphore("sem:clock", 1)
+
+	if _, err := sem.TryAcquire(ctx, WithExpiry(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	// 是否过期只看 redis 的时钟，和调用方的本地时间无关
+	mr.SetTime(time.Now().Add(2 * time.Minute))
+	h, err := sem.TryAcquire(ctx, WithExpiry(time.Minute))
+	if err != nil {
+		t.Fatalf("expired holder not evicted: %v", err)
+	}
+	h.Unlock(ctx)
+}